@@ -0,0 +1,139 @@
+package systray
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// iconAnimation is owned by the loop thread
+type iconAnimation struct {
+	frames   [][]byte
+	interval time.Duration
+	frame    int
+}
+
+// SetIconAnimation will cycle the tray icon through the frames, showing each one for the interval, until StopIconAnimation is called.
+// Every frame must be in the format SetIcon takes, all of them are loaded before the animation starts.
+// The animation pauses while the icon is hidden with SetVisible
+func (t *Tray) SetIconAnimation(frames [][]byte, interval time.Duration) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("%w: no animation frames", ErrInvalidIcon)
+	}
+	if interval <= 0 {
+		return errors.New("systray: animation interval must be positive")
+	}
+	for i, frame := range frames {
+		if err := validateIcon(frame); err != nil {
+			return fmt.Errorf("animation frame %d: %w", i, err)
+		}
+	}
+
+	frames = append([][]byte(nil), frames...)
+
+	return t.runOnLoop(func() error {
+		// The frames are only reserved once all of them loaded, so a frame failing leaves the cache as it was.
+		// Loading them again after reserving brings back frames that were dropped to make room for the later ones
+		if err := t.loadFrames(frames); err != nil {
+			return err
+		}
+		previous := t.iconFrames
+		t.reserveIcons(len(frames))
+		if err := t.loadFrames(frames); err != nil {
+			t.reserveIcons(previous)
+			return err
+		}
+
+		t.stopAnimation()
+		if err := t.showIcon(frames[0]); err != nil {
+			return err
+		}
+		t.animation = &iconAnimation{
+			frames:   frames,
+			interval: interval,
+		}
+
+		if t.hidden {
+			return nil
+		}
+		return t.startAnimationTimer(interval)
+	})
+}
+
+// StopIconAnimation will stop the animation started with SetIconAnimation and show the icon set with SetIcon again,
+// or no icon when none has been set
+func (t *Tray) StopIconAnimation() error {
+	return t.runOnLoop(func() error {
+		if t.animation == nil {
+			return nil
+		}
+		t.stopAnimation()
+		t.reserveIcons(0)
+
+		if t.icon == nil {
+			return t.clearIcon()
+		}
+		return t.showIcon(t.icon)
+	})
+}
+
+// SetVisible will show or hide the tray icon without removing the tray, a hidden icon keeps its menu and settings
+func (t *Tray) SetVisible(visible bool) error {
+	return t.runOnLoop(func() error {
+		if err := t.setVisible(visible); err != nil {
+			return err
+		}
+		t.hidden = !visible
+
+		if t.animation == nil {
+			return nil
+		}
+		if t.hidden {
+			t.stopAnimationTimer()
+			return nil
+		}
+		return t.startAnimationTimer(t.animation.interval)
+	})
+}
+
+// loadFrames composes and loads the frames of an animation, it must be called on the loop thread
+func (t *Tray) loadFrames(frames [][]byte) error {
+	for _, frame := range frames {
+		decorated, err := t.decorateIcon(frame)
+		if err != nil {
+			return err
+		}
+		if err := t.loadIcon(decorated); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reserveIcons keeps the frames of an animation on top of the composed and created icons usually kept, it must be called on the loop thread
+func (t *Tray) reserveIcons(frames int) {
+	t.iconFrames = frames
+	t.reserveIconCache(frames)
+}
+
+// stopAnimation must be called on the loop thread
+func (t *Tray) stopAnimation() {
+	if t.animation != nil {
+		t.stopAnimationTimer()
+		t.animation = nil
+	}
+}
+
+// onAnimationFrame is called on the loop thread when the next frame of the animation is due
+func (t *Tray) onAnimationFrame() {
+	animation := t.animation
+	if animation == nil {
+		return
+	}
+
+	animation.frame = (animation.frame + 1) % len(animation.frames)
+	if err := t.showIcon(animation.frames[animation.frame]); err != nil {
+		t.reportError(err)
+	}
+}
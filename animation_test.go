@@ -0,0 +1,134 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// animating reports whether the animation timer of the fake backend is running
+func (n *nativeTray) animating() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.animation != nil
+}
+
+// waitForIcon fails the test unless the icon is shown within a second
+func waitForIcon(tb testing.TB, tray *Tray, icon []byte, what string) {
+	tb.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !bytes.Equal(tray.native.shownIcon(), icon) {
+		if time.Now().After(deadline) {
+			tb.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAnimationPausesWhileHidden(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	frames := [][]byte{testIcon(t, color.White), testIcon(t, color.Black)}
+	if err := tray.SetIconAnimation(frames, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	waitForIcon(t, tray, frames[1], "the second frame")
+
+	if err := tray.SetVisible(false); err != nil {
+		t.Fatal(err)
+	}
+	if tray.native.animating() {
+		t.Fatal("the animation timer runs while the icon is hidden")
+	}
+	paused := tray.native.shownIcon()
+	time.Sleep(50 * time.Millisecond)
+	if !bytes.Equal(tray.native.shownIcon(), paused) {
+		t.Error("the frame changed while the icon is hidden")
+	}
+
+	// Showing the icon again resumes the animation
+	if err := tray.SetVisible(true); err != nil {
+		t.Fatal(err)
+	}
+	if !tray.native.animating() {
+		t.Fatal("the animation timer was not started again once the icon is shown")
+	}
+	for _, frame := range frames {
+		waitForIcon(t, tray, frame, "the animation to resume")
+	}
+}
+
+func TestStopAnimationRestoresIcon(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	icon := testIcon(t, color.White)
+	if err := tray.SetIcon(icon); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := [][]byte{testIcon(t, color.Black), testIcon(t, color.Gray{Y: 0x80})}
+	for run := 0; run < 2; run++ {
+		if err := tray.SetIconAnimation(frames, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		waitForIcon(t, tray, frames[1], "the animation to run")
+
+		if err := tray.StopIconAnimation(); err != nil {
+			t.Fatal(err)
+		}
+		if tray.native.animating() {
+			t.Fatal("the animation timer runs after the animation stopped")
+		}
+		if !bytes.Equal(tray.native.shownIcon(), icon) {
+			t.Errorf("run %d: the icon shown once the animation stopped is not the one set with SetIcon", run)
+		}
+
+		// Without an icon of its own the tray shows none
+		tray.runOnLoop(func() error {
+			tray.icon = nil
+			return nil
+		})
+		icon = nil
+	}
+}
+
+func TestFailedAnimationLeavesIconsAlone(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	// The badge is drawn onto every frame, which fails for a frame whose image can not be decoded
+	if err := tray.SetBadge("1"); err != nil {
+		t.Fatal(err)
+	}
+	running := [][]byte{testIcon(t, color.White), testIcon(t, color.Black)}
+	if err := tray.SetIconAnimation(running, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := icoWithEntry(make([]byte, 40))
+	frames := [][]byte{testIcon(t, color.Gray{Y: 0x40}), testIcon(t, color.Gray{Y: 0x80}), broken}
+	if err := tray.SetIconAnimation(frames, 10*time.Millisecond); err == nil {
+		t.Fatal("SetIconAnimation succeeded with a frame that can not be drawn")
+	}
+
+	var reserved int
+	var animation *iconAnimation
+	tray.runOnLoop(func() error {
+		reserved = tray.iconFrames
+		animation = tray.animation
+		return nil
+	})
+	if reserved != len(running) {
+		t.Errorf("%d icons are reserved after the failed animation, want the %d of the running one", reserved, len(running))
+	}
+	if animation == nil || len(animation.frames) != len(running) {
+		t.Error("the running animation was replaced by the failed one")
+	}
+}
@@ -0,0 +1,474 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The fake backend stands in for the platform tray in tests. It runs the loop on the goroutine calling nativeLoop,
+// delivering posted messages the way a window procedure would, and keeps track of the resources it hands out so
+// tests can check they are released
+
+// fakeTheme is the theme the fake desktop reports, changed with setFakeTheme
+var fakeTheme int32
+
+func setFakeTheme(theme Theme) {
+	atomic.StoreInt32(&fakeTheme, int32(theme))
+}
+
+// fakeItem is the state of a menu item as the fake backend last saw it
+type fakeItem struct {
+	title     string
+	checked   bool
+	disabled  bool
+	isDefault bool
+	separator bool
+	parent    uintptr
+}
+
+type nativeTray struct {
+	loopID   int64
+	messages chan func()
+	wake     chan struct{}
+	closing  chan struct{}
+
+	lock        sync.Mutex
+	nextHandle  uintptr
+	menus       map[uintptr]bool
+	icons       map[string]bool
+	items       map[int32]fakeItem
+	shown       []byte
+	tooltip     string
+	visible     bool
+	doubleClick bool
+	menuShown   int
+	animation   chan struct{}
+	deinits     int
+	failItems   bool
+	failInit    bool
+}
+
+func (t *Tray) initNative() {}
+
+func nativeTheme() Theme {
+	return Theme(atomic.LoadInt32(&fakeTheme))
+}
+
+func setNativeTrace(enabled bool) {}
+
+func goroutineID() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	s := strings.TrimPrefix(string(buf[:n]), "goroutine ")
+	id, _ := strconv.ParseInt(s[:strings.IndexByte(s, ' ')], 10, 64)
+	return id
+}
+
+func (t *Tray) isLoopThread() bool {
+	id := atomic.LoadInt64(&t.native.loopID)
+	return id != 0 && id == goroutineID()
+}
+
+func (t *Tray) wakeLoop() {
+	select {
+	case t.native.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Tray) quit() {
+	select {
+	case t.native.closing <- struct{}{}:
+	default:
+	}
+}
+
+// post delivers f to the loop like a window message, it is dropped when the loop is not running
+func (n *nativeTray) post(f func()) {
+	n.lock.Lock()
+	messages := n.messages
+	n.lock.Unlock()
+
+	if messages != nil {
+		messages <- f
+	}
+}
+
+// fakeClick delivers a click on the tray icon, like the window procedure once it has told single and double clicks apart
+func (t *Tray) fakeClick(gesture Gesture, x, y int) {
+	t.native.post(func() {
+		t.onTrayClick(gesture, x, y)
+	})
+}
+
+// fakeHostLost reports that the icon could not be added again after the tray host restarted, like the window procedure on TaskbarCreated
+func (t *Tray) fakeHostLost() {
+	t.native.post(func() {
+		t.onHostDisappeared(errors.New("fake: the notification area is gone"))
+	})
+}
+
+// fakeSettingChange reports that the settings of the desktop changed, like the window procedure on WM_SETTINGCHANGE
+func (t *Tray) fakeSettingChange() {
+	t.native.post(t.updateTheme)
+}
+
+func (n *nativeTray) menusShown() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.menuShown
+}
+
+func (n *nativeTray) detectsDoubleClicks() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.doubleClick
+}
+
+func (t *Tray) showTrayMenu() {
+	t.onMenuOpened(t.menu)
+	t.native.lock.Lock()
+	t.native.menuShown++
+	t.native.lock.Unlock()
+	t.onMenuClosed(t.menu)
+}
+
+func (t *Tray) setDoubleClickDetection(enabled bool) {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.doubleClick = enabled
+}
+
+func (t *Tray) setTooltip(tooltip string) error {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.tooltip = tooltip
+	return nil
+}
+
+func (n *nativeTray) newHandle() uintptr {
+	n.nextHandle++
+	n.menus[n.nextHandle] = true
+	return n.nextHandle
+}
+
+func (n *nativeTray) storeItem(menuItem *MenuItem, separator bool) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.failItems {
+		return errors.New("fake: updating menu items is failing")
+	}
+	if !n.menus[menuItem.parent.handle] {
+		return fmt.Errorf("fake: menu %d does not exist", menuItem.parent.handle)
+	}
+
+	menuItem.parent.AddNewMenuItem(menuItem)
+	n.items[menuItem.GetID()] = fakeItem{
+		title:     menuItem.GetTitle(),
+		checked:   menuItem.IsChecked(),
+		disabled:  menuItem.IsDisabled(),
+		isDefault: menuItem.IsDefault(),
+		separator: separator,
+		parent:    menuItem.parent.handle,
+	}
+	return nil
+}
+
+func (t *Tray) addSeperator(menuItem *MenuItem) error {
+	return t.native.storeItem(menuItem, true)
+}
+
+func (t *Tray) setMenuItem(menuItem *MenuItem) error {
+	return t.native.storeItem(menuItem, false)
+}
+
+func (t *Tray) setDefaultItem(menuItem *MenuItem) error {
+	return t.native.storeItem(menuItem, false)
+}
+
+func (t *Tray) clearDefaultItem(menuItem *MenuItem) error {
+	return t.native.storeItem(menuItem, false)
+}
+
+func (t *Tray) addSubMenuItem(menuItem *MenuItem) (*Menu, error) {
+	if err := t.native.storeItem(menuItem, false); err != nil {
+		return nil, err
+	}
+
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	return &Menu{tray: t, handle: t.native.newHandle()}, nil
+}
+
+func (t *Tray) createMenu() (*Menu, error) {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	return &Menu{tray: t, handle: t.native.newHandle()}, nil
+}
+
+func encodeNativeIcon(imgs ...image.Image) ([]byte, error) {
+	return encodeICO(imgs, icoSizes)
+}
+
+func validateIcon(iconBytes []byte) error {
+	if _, err := decodeICO(iconBytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+
+	return nil
+}
+
+func decodeNativeIcon(iconBytes []byte, size int) (image.Image, error) {
+	entries, err := decodeICO(iconBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeICOImage(pickICOEntry(entries, size))
+}
+
+func (t *Tray) menuIconSize() int {
+	return 16
+}
+
+func (t *Tray) loadIcon(iconBytes []byte) error {
+	if err := validateIcon(iconBytes); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(iconBytes)
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.icons[string(sum[:])] = true
+	return nil
+}
+
+func (t *Tray) reserveIconCache(frames int) {}
+
+func (t *Tray) setIcon(iconBytes []byte) error {
+	if err := t.loadIcon(iconBytes); err != nil {
+		return err
+	}
+
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.shown = iconBytes
+	return nil
+}
+
+func (t *Tray) clearIcon() error {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.shown = nil
+	return nil
+}
+
+func (t *Tray) setVisible(visible bool) error {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+	t.native.visible = visible
+	return nil
+}
+
+func (t *Tray) startAnimationTimer(interval time.Duration) error {
+	t.stopAnimationTimer()
+
+	stop := make(chan struct{})
+	t.native.lock.Lock()
+	t.native.animation = stop
+	t.native.lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.native.post(t.onAnimationFrame)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (t *Tray) stopAnimationTimer() {
+	t.native.lock.Lock()
+	defer t.native.lock.Unlock()
+
+	if t.native.animation != nil {
+		close(t.native.animation)
+		t.native.animation = nil
+	}
+}
+
+// nativeAttach creates the fake window for a loop the test runs itself with pumpMessage
+func (t *Tray) nativeAttach() error {
+	n := &t.native
+	if err := n.init(); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&n.loopID, goroutineID())
+
+	t.onTrayRun()
+	t.startDispatching()
+
+	return nil
+}
+
+// pumpMessage handles the next message of an attached tray, like the loop of the application would.
+// It returns false once the tray has been torn down
+func (t *Tray) pumpMessage() bool {
+	n := &t.native
+	n.lock.Lock()
+	messages := n.messages
+	n.lock.Unlock()
+
+	if messages == nil {
+		return false
+	}
+
+	select {
+	case <-n.wake:
+		t.drainQueue()
+	case f := <-messages:
+		f()
+	case <-n.closing:
+		t.onTrayExit()
+		t.stopDispatching()
+		atomic.StoreInt64(&n.loopID, 0)
+		n.deinit()
+		t.finishExit()
+
+		// Locked by Attach
+		runtime.UnlockOSThread()
+		return false
+	}
+
+	return true
+}
+
+// init creates the fake window, like InitInstance, failing while setFailInit is set
+func (n *nativeTray) init() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.failInit {
+		return errors.New("fake: unable to create the window")
+	}
+
+	n.messages = make(chan func(), 64)
+	n.wake = make(chan struct{}, 1)
+	n.closing = make(chan struct{}, 1)
+	n.menus = make(map[uintptr]bool)
+	n.icons = make(map[string]bool)
+	n.items = make(map[int32]fakeItem)
+	n.shown = nil
+	n.tooltip = ""
+	n.visible = true
+	n.doubleClick = false
+	return nil
+}
+
+// deinit releases everything the fake window handed out, like DeInit
+func (n *nativeTray) deinit() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.animation != nil {
+		close(n.animation)
+		n.animation = nil
+	}
+	n.messages = nil
+	n.menus = nil
+	n.icons = nil
+	n.deinits++
+}
+
+// live returns how many menus and icons the fake backend is holding on to
+func (n *nativeTray) live() (menus, icons int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return len(n.menus), len(n.icons)
+}
+
+// setFailInit makes creating the fake window fail until it is switched back
+func (n *nativeTray) setFailInit(fail bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failInit = fail
+}
+
+// setFailItems makes every menu item update fail until it is switched back
+func (n *nativeTray) setFailItems(fail bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failItems = fail
+}
+
+func (n *nativeTray) item(id int32) (fakeItem, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	item, ok := n.items[id]
+	return item, ok
+}
+
+func (n *nativeTray) shownTooltip() string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.tooltip
+}
+
+func (n *nativeTray) shownIcon() []byte {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.shown
+}
+
+func (t *Tray) nativeLoop() error {
+	n := &t.native
+	if err := n.init(); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&n.loopID, goroutineID())
+
+	n.lock.Lock()
+	messages := n.messages
+	n.lock.Unlock()
+
+	defer func() {
+		t.stopDispatching()
+		atomic.StoreInt64(&n.loopID, 0)
+		n.deinit()
+	}()
+
+	t.onTrayRun()
+	t.startDispatching()
+
+	for {
+		select {
+		case <-n.wake:
+			t.drainQueue()
+		case f := <-messages:
+			f()
+		case <-n.closing:
+			// The window is destroyed, removing the icon, before the loop returns
+			t.onTrayExit()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package systray
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Corner is where an overlay is drawn on the tray icon
+type Corner int
+
+const (
+	// CornerBottomRight is the default corner, where a badge does not cover the overlay
+	CornerBottomRight Corner = iota
+	// CornerBottomLeft is the bottom left corner of the icon
+	CornerBottomLeft
+	// CornerTopRight is the top right corner of the icon, shared with the badge
+	CornerTopRight
+	// CornerTopLeft is the top left corner of the icon
+	CornerTopLeft
+)
+
+// decoratedIcons is how many composed icons are kept besides the frames of an animation
+const decoratedIcons = 8
+
+// badgeColor is the background of the bubble drawn by SetBadge
+var badgeColor = color.RGBA{R: 0xd9, G: 0x1e, B: 0x18, A: 0xff}
+
+// badgeGlyphs is a 3x5 pixel font for the characters a badge can show, each row uses the low 3 bits with the leftmost pixel first
+var badgeGlyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'+': {0, 2, 7, 2, 0},
+	'!': {2, 2, 2, 0, 2},
+}
+
+// SetBadge will draw a bubble with the text in the top right corner of the tray icon, e.g. an unread count.
+// The text can use the digits, '+' and '!', an empty text removes the badge. The badge is drawn at every size of the icon
+// and stays when the icon is changed or animated
+func (t *Tray) SetBadge(text string) error {
+	for _, r := range text {
+		if _, ok := badgeGlyphs[r]; !ok {
+			return fmt.Errorf("systray: badge can not show %q", r)
+		}
+	}
+
+	return t.runOnLoop(func() error {
+		t.badge = text
+		return t.refreshIcon()
+	})
+}
+
+// SetOverlay will draw the image in a corner of the tray icon at half its size, e.g. a status dot. A nil image removes the overlay.
+// Like the badge it is drawn at every size of the icon and stays when the icon is changed or animated
+func (t *Tray) SetOverlay(img image.Image, corner Corner) error {
+	if img != nil && img.Bounds().Empty() {
+		return fmt.Errorf("%w: empty overlay image", ErrInvalidIcon)
+	}
+
+	return t.runOnLoop(func() error {
+		t.overlay = img
+		t.overlayCorner = corner
+		return t.refreshIcon()
+	})
+}
+
+// refreshIcon shows the current icon or animation frame again after its decoration changed, it must be called on the loop thread
+func (t *Tray) refreshIcon() error {
+	t.decorated = nil
+	return t.showCurrentIcon()
+}
+
+// showCurrentIcon shows the current icon or animation frame again, it must be called on the loop thread
+func (t *Tray) showCurrentIcon() error {
+	if t.animation != nil {
+		return t.showIcon(t.animation.frames[t.animation.frame])
+	}
+	if t.icon != nil {
+		return t.showIcon(t.icon)
+	}
+
+	return nil
+}
+
+// showIcon shows the icon with its badge and overlay, it must be called on the loop thread
+func (t *Tray) showIcon(iconBytes []byte) error {
+	decorated, err := t.decorateIcon(iconBytes)
+	if err != nil {
+		return err
+	}
+
+	return t.setIcon(decorated)
+}
+
+// decorateIcon returns the icon with the badge and overlay drawn onto it, it must be called on the loop thread.
+// The result is kept until the decoration changes, so an animation composes each frame once. Icons set one after another
+// are not kept forever: once more than the frames and a few others are kept they are all composed again as they are shown
+func (t *Tray) decorateIcon(iconBytes []byte) ([]byte, error) {
+	if t.badge == "" && t.overlay == nil {
+		return iconBytes, nil
+	}
+
+	sum := sha256.Sum256(iconBytes)
+	if decorated, ok := t.decorated[sum]; ok {
+		return decorated, nil
+	}
+
+	decorated, err := composeIcon(iconBytes, t.badge, t.overlay, t.overlayCorner)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to draw badge or overlay: %v", ErrInvalidIcon, err)
+	}
+
+	if t.decorated == nil || len(t.decorated) >= decoratedIcons+t.iconFrames {
+		t.decorated = make(map[[sha256.Size]byte][]byte)
+	}
+	t.decorated[sum] = decorated
+
+	return decorated, nil
+}
+
+// composeIcon will draw the badge and overlay onto every size in an .ico file
+func composeIcon(iconBytes []byte, badge string, overlay image.Image, corner Corner) ([]byte, error) {
+	entries, err := decodeICO(iconBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the entry with the most colours is kept for each size
+	bySize := make(map[int]icoEntry)
+	var sizes []int
+	for _, entry := range entries {
+		best, ok := bySize[entry.Width]
+		if !ok {
+			sizes = append(sizes, entry.Width)
+		}
+		if !ok || entry.BitCount > best.BitCount {
+			bySize[entry.Width] = entry
+		}
+	}
+
+	images := make([]image.Image, 0, len(sizes))
+	for _, size := range sizes {
+		img, err := decodeICOImage(bySize[size])
+		if err != nil {
+			return nil, err
+		}
+
+		canvas := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+
+		if overlay != nil {
+			drawOverlay(canvas, overlay, corner)
+		}
+		if badge != "" {
+			drawBadge(canvas, badge)
+		}
+
+		images = append(images, canvas)
+	}
+
+	return encodeICOImages(images)
+}
+
+func drawOverlay(canvas *image.RGBA, overlay image.Image, corner Corner) {
+	width, height := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+	size := width / 2
+	if size < 6 {
+		size = 6
+	}
+
+	var at image.Point
+	switch corner {
+	case CornerBottomRight:
+		at = image.Pt(width-size, height-size)
+	case CornerBottomLeft:
+		at = image.Pt(0, height-size)
+	case CornerTopRight:
+		at = image.Pt(width-size, 0)
+	}
+
+	scaled := scaleImage(overlay, size)
+	draw.Draw(canvas, scaled.Bounds().Add(at), scaled, image.Point{}, draw.Over)
+}
+
+// drawBadge will draw a rounded bubble with the text in the top right corner, scaling the font to the size of the icon
+func drawBadge(canvas *image.RGBA, text string) {
+	width := canvas.Bounds().Dx()
+
+	height := width * 9 / 16
+	if height < 7 {
+		height = 7
+	}
+	scale := (height - 4) / 5
+	if scale < 1 {
+		scale = 1
+	}
+
+	glyphs := []rune(text)
+	textWidth := len(glyphs)*3*scale + (len(glyphs)-1)*scale
+	bubbleWidth := textWidth + height - 5*scale
+	if bubbleWidth < height {
+		bubbleWidth = height
+	}
+	if bubbleWidth > width {
+		bubbleWidth = width
+	}
+
+	bubble := image.Rect(width-bubbleWidth, 0, width, height)
+	drawPill(canvas, bubble, badgeColor)
+
+	x := bubble.Min.X + (bubbleWidth-textWidth)/2
+	y := bubble.Min.Y + (height-5*scale)/2
+	for _, r := range glyphs {
+		glyph := badgeGlyphs[r]
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				if glyph[row]&(4>>uint(col)) == 0 {
+					continue
+				}
+
+				pixel := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(canvas, pixel.Intersect(canvas.Bounds()), image.White, image.Point{}, draw.Src)
+			}
+		}
+		x += 4 * scale
+	}
+}
+
+// drawPill will fill the rectangle with fully rounded ends, anti-aliased by sampling each pixel 4x4 times
+func drawPill(canvas *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	radius := float64(rect.Dy()) / 2
+	left := float64(rect.Min.X) + radius
+	right := float64(rect.Max.X) - radius
+	centerY := float64(rect.Min.Y) + radius
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			inside := 0
+			for sy := 0; sy < 4; sy++ {
+				for sx := 0; sx < 4; sx++ {
+					px := float64(x) + (float64(sx)+0.5)/4
+					py := float64(y) + (float64(sy)+0.5)/4
+
+					dx := 0.0
+					if px < left {
+						dx = left - px
+					} else if px > right {
+						dx = px - right
+					}
+					dy := py - centerY
+
+					if dx*dx+dy*dy <= radius*radius {
+						inside++
+					}
+				}
+			}
+			if inside == 0 {
+				continue
+			}
+
+			coverage := uint8(inside * 0xff / 16)
+			src := image.NewUniform(color.RGBA{
+				R: uint8(uint16(c.R) * uint16(coverage) / 0xff),
+				G: uint8(uint16(c.G) * uint16(coverage) / 0xff),
+				B: uint8(uint16(c.B) * uint16(coverage) / 0xff),
+				A: coverage,
+			})
+			draw.Draw(canvas, image.Rect(x, y, x+1, y+1), src, image.Point{}, draw.Over)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestDecoratedIconsAreBounded(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	kept := func() (n int) {
+		tray.runOnLoop(func() error {
+			n = len(tray.decorated)
+			return nil
+		})
+		return n
+	}
+
+	if err := tray.SetBadge("1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < decoratedIcons+4; i++ {
+		if err := tray.SetIcon(testIcon(t, color.RGBA{R: uint8(i), A: 0xff})); err != nil {
+			t.Fatal(err)
+		}
+		if n := kept(); n > decoratedIcons {
+			t.Fatalf("%d composed icons are kept after setting %d icons, want at most %d", n, i+1, decoratedIcons)
+		}
+	}
+
+	// Every frame of an animation stays composed while it runs
+	frames := make([][]byte, decoratedIcons+4)
+	for i := range frames {
+		frames[i] = testIcon(t, color.RGBA{G: uint8(i), A: 0xff})
+	}
+	if err := tray.SetIconAnimation(frames, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := kept(); n < len(frames) {
+		t.Errorf("%d composed icons are kept while animating %d frames", n, len(frames))
+	}
+
+	if err := tray.StopIconAnimation(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < decoratedIcons+4; i++ {
+		if err := tray.SetIcon(testIcon(t, color.RGBA{B: uint8(i), A: 0xff})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := kept(); n > decoratedIcons {
+		t.Errorf("%d composed icons are kept once the animation stopped, want at most %d", n, decoratedIcons)
+	}
+}
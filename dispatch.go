@@ -0,0 +1,101 @@
+package systray
+
+const (
+	// Functions are queued until the loop starts
+	dispatchPending = iota
+	// Functions are queued and the loop is woken to run them
+	dispatchRunning
+	// The loop has exited, nothing will run queued functions anymore
+	dispatchStopped
+)
+
+// Do will queue f to be run on the tray's loop thread, where all changes to the tray and its menus are made.
+// It returns without waiting for f to run. Functions queued before the tray is running are run once it has started,
+// functions queued after the tray has exited are never run
+func (t *Tray) Do(f func()) {
+	t.dispatchQueueLock.Lock()
+	defer t.dispatchQueueLock.Unlock()
+
+	switch t.dispatchState {
+	case dispatchPending:
+		t.dispatchQueue = append(t.dispatchQueue, f)
+	case dispatchRunning:
+		t.dispatchQueue = append(t.dispatchQueue, f)
+		t.wakeLoop()
+	}
+}
+
+// runOnLoop will run f on the loop thread and wait for it to complete, returning its error.
+// ErrNotRunning or ErrClosed are returned without running f when the tray is not running
+func (t *Tray) runOnLoop(f func() error) error {
+	if err := t.checkRunning(); err != nil {
+		return err
+	}
+
+	if t.isLoopThread() {
+		return f()
+	}
+
+	var err error
+	done := make(chan struct{})
+
+	t.dispatchQueueLock.Lock()
+	if t.dispatchState == dispatchStopped {
+		t.dispatchQueueLock.Unlock()
+		return ErrClosed
+	}
+	t.dispatchQueue = append(t.dispatchQueue, func() {
+		defer close(done)
+		err = f()
+	})
+	if t.dispatchState == dispatchRunning {
+		t.wakeLoop()
+	}
+	t.dispatchQueueLock.Unlock()
+
+	<-done
+	return err
+}
+
+// startDispatching is called from the loop thread once it is able to process queued functions
+func (t *Tray) startDispatching() {
+	t.dispatchQueueLock.Lock()
+	defer t.dispatchQueueLock.Unlock()
+
+	t.dispatchState = dispatchRunning
+	if len(t.dispatchQueue) > 0 {
+		t.wakeLoop()
+	}
+}
+
+// stopDispatching is called from the loop thread as it exits, anything still queued is run before returning
+func (t *Tray) stopDispatching() {
+	t.dispatchQueueLock.Lock()
+	t.dispatchState = dispatchStopped
+	t.dispatchQueueLock.Unlock()
+
+	t.drainQueue()
+}
+
+// resetDispatch prepares queueing for the next run, functions queued before the first run are kept
+func (t *Tray) resetDispatch() {
+	t.dispatchQueueLock.Lock()
+	defer t.dispatchQueueLock.Unlock()
+
+	if t.dispatchState == dispatchStopped {
+		t.dispatchState = dispatchPending
+		t.dispatchQueue = nil
+	}
+}
+
+// drainQueue will run everything currently queued, it must only be called from the loop thread
+func (t *Tray) drainQueue() {
+	t.dispatchQueueLock.Lock()
+	queue := t.dispatchQueue
+	t.dispatchQueue = nil
+	t.dispatchQueueLock.Unlock()
+
+	for _, f := range queue {
+		t.safeCall(f)
+	}
+}
@@ -0,0 +1,4 @@
+// Package systray shows an icon with a menu in the notification area of Windows.
+// Windows is the only platform with a backend: Linux, with StatusNotifierItem and the freedesktop desktop services, is out of scope,
+// the package only builds there for its tests
+package systray
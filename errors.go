@@ -0,0 +1,95 @@
+package systray
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+var (
+	// ErrQuit is returned by RunContext when the tray application was closed using Quit
+	ErrQuit = errors.New("systray: quit")
+	// ErrSessionEnd is returned by RunContext when the tray application was closed because the user logged out or the system is shutting down
+	ErrSessionEnd = errors.New("systray: session ended")
+	// ErrNotRunning is returned when the tray is used before Run has started it
+	ErrNotRunning = errors.New("systray: tray is not running")
+	// ErrAlreadyRunning is returned by RunContext, Start and Attach until Done of the previous run has been closed
+	ErrAlreadyRunning = errors.New("systray: tray is already running")
+	// ErrClosed is returned when the tray is used after Quit or after it has exited
+	ErrClosed = errors.New("systray: tray is closed")
+	// ErrHostDisappeared is returned by RunContext when the tray application was closed because the icon could not be shown again
+	// after the tray host restarted, e.g. when Explorer crashed
+	ErrHostDisappeared = errors.New("systray: tray host disappeared")
+	// ErrSignal is returned by RunContext when the tray application was closed by a signal passed to HandleSignals
+	ErrSignal = errors.New("systray: closed by signal")
+	// ErrExitVetoed is returned by Quit when a hook registered with OnBeforeExit vetoed the exit
+	ErrExitVetoed = errors.New("systray: exit vetoed")
+	// ErrInvalidIcon is returned when icon data is empty or not in a format the platform can load
+	ErrInvalidIcon = errors.New("systray: invalid icon")
+)
+
+// PanicError is passed to the error handler when a callback panics, the tray keeps running
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("systray: callback panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// stderr receives the errors nothing else handles, see logError
+var stderr io.Writer = os.Stderr
+
+// SetErrorHandler will set a handler receiving errors that can not be returned to the caller,
+// such as panics in callbacks and failures of the platform tray. Passing nil restores the default, which logs the error
+// or writes it to stderr when no logger is set
+func (t *Tray) SetErrorHandler(handler func(error)) {
+	t.errorHandlerLock.Lock()
+	defer t.errorHandlerLock.Unlock()
+	t.errorHandler = handler
+}
+
+func (t *Tray) reportError(err error) {
+	t.errorHandlerLock.RLock()
+	handler := t.errorHandler
+	t.errorHandlerLock.RUnlock()
+
+	if handler == nil {
+		logError(err)
+		return
+	}
+
+	// A broken error handler must not take the tray down with it
+	defer func() {
+		if r := recover(); r != nil {
+			logError(fmt.Errorf("systray: error handler panicked: %v, handling: %v", r, err))
+		}
+	}()
+	handler(err)
+}
+
+// logError passes an error no handler received to the logger, writing it to stderr when no logger is set so it is not lost
+func logError(err error) {
+	if !log.enabled() {
+		fmt.Fprintln(stderr, err)
+		return
+	}
+
+	log.Errorf("%v", err)
+}
+
+// safeCall runs a user supplied callback, reporting a panic to the error handler instead of crashing the tray
+func (t *Tray) safeCall(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.reportError(&PanicError{Value: r, Stack: debug.Stack()})
+		}
+	}()
+
+	f()
+}
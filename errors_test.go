@@ -0,0 +1,174 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer standing in for stderr, which the loop thread writes to while the test reads it
+type syncBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// testLogger collects the errors logged through it
+type testLogger struct {
+	errors chan string
+}
+
+func (l testLogger) Debugf(format string, args ...interface{}) {}
+
+func (l testLogger) Errorf(format string, args ...interface{}) {
+	l.errors <- fmt.Sprintf(format, args...)
+}
+
+func TestPanickingHandlersAreRecovered(t *testing.T) {
+	for _, mode := range []HandlerMode{HandlerInline, HandlerSerial, HandlerConcurrent} {
+		func() {
+			reported := make(chan error, 4)
+			tray, stop := startTestTray(t, Options{
+				Handlers: HandlerOptions{Mode: mode},
+				ErrorHandler: func(err error) {
+					reported <- err
+				},
+			})
+			defer stop()
+
+			panicking, err := tray.AddMenuItem("Panics", func(*MenuItem) {
+				panic("boom")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			withoutHandler, err := tray.AddMenuItem("No handler", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clicked := make(chan struct{})
+			working, err := tray.AddMenuItem("Works", func(*MenuItem) {
+				close(clicked)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range []int32{panicking.GetID(), withoutHandler.GetID(), 1000, working.GetID()} {
+				id := id
+				tray.native.post(func() {
+					tray.onMenuItemSelected(id)
+				})
+			}
+			waitFor(t, clicked, "the handler after the panic to run")
+
+			var panicErr *PanicError
+			var unknown error
+			for i := 0; i < 2; i++ {
+				select {
+				case err := <-reported:
+					if !errors.As(err, &panicErr) {
+						unknown = err
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("mode %v: only %d errors were reported, want the panic and the unknown item", mode, i)
+				}
+			}
+			if panicErr == nil || panicErr.Value != "boom" || !bytes.Contains(panicErr.Stack, []byte("errors_test.go")) {
+				t.Errorf("mode %v: the panic was reported as %v", mode, panicErr)
+			}
+			if unknown == nil || !strings.Contains(unknown.Error(), "1000") {
+				t.Errorf("mode %v: selecting an unknown item was reported as %v", mode, unknown)
+			}
+		}()
+	}
+}
+
+func TestUnhandledErrorsAreNotLost(t *testing.T) {
+	output := &syncBuffer{}
+	stderr = output
+	defer func() {
+		stderr = os.Stderr
+	}()
+
+	tray := New(Options{})
+	stop, err := tray.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	panics := func(value string) {
+		clicked := make(chan struct{})
+		tray.OnSecondaryActivate(func(x, y int) {
+			defer close(clicked)
+			panic(value)
+		})
+		tray.fakeClick(GestureSecondary, 0, 0)
+		waitFor(t, clicked, "the callback to run")
+
+		// The panic is reported once the callback has unwound
+		handled := make(chan struct{})
+		tray.native.post(func() {
+			close(handled)
+		})
+		waitFor(t, handled, "the panic to be reported")
+	}
+
+	// Without an error handler or a logger errors are written to stderr
+	panics("to stderr")
+	if !strings.Contains(output.String(), "to stderr") {
+		t.Errorf("the panic was not written to stderr, got %q", output.String())
+	}
+
+	// A logger receives them instead
+	logger := testLogger{errors: make(chan string, 1)}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	panics("to logger")
+	select {
+	case logged := <-logger.errors:
+		if !strings.Contains(logged, "to logger") {
+			t.Errorf("logged %q, want the panic", logged)
+		}
+	default:
+		t.Error("the panic was not logged")
+	}
+	if strings.Contains(output.String(), "to logger") {
+		t.Error("the panic was written to stderr although a logger is set")
+	}
+
+	// So does an error the error handler panicked on
+	tray.SetErrorHandler(func(err error) {
+		panic("broken handler")
+	})
+	panics("with broken handler")
+	select {
+	case logged := <-logger.errors:
+		if !strings.Contains(logged, "broken handler") || !strings.Contains(logged, "with broken handler") {
+			t.Errorf("logged %q, want the panic of the handler and the error it handled", logged)
+		}
+	default:
+		t.Error("the panic of the error handler was not logged")
+	}
+}
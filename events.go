@@ -0,0 +1,130 @@
+package systray
+
+import (
+	"sync/atomic"
+)
+
+// DefaultEventBuffer is the number of events buffered by the Events channel unless changed with SetEventBuffer
+const DefaultEventBuffer = 16
+
+// EventType identifies what happened in the tray application
+type EventType int
+
+const (
+	// EventItemClicked is sent when a menu item is clicked
+	EventItemClicked EventType = iota
+	// EventCheckedChanged is sent when the checked state of a menu item changes
+	EventCheckedChanged
+	// EventMenuOpened is sent when the tray menu or one of its sub menus is shown
+	EventMenuOpened
+	// EventMenuClosed is sent when the tray menu or one of its sub menus is dismissed
+	EventMenuClosed
+	// EventIconActivated is sent when the tray icon is clicked, Gesture tells how
+	EventIconActivated
+	// EventExit is sent once the tray application has shut down
+	EventExit
+	// EventSession is sent when the session is locked or unlocked or the machine suspends or resumes, Session tells which
+	EventSession
+	// EventThemeChanged is sent when the theme of the panel changes, Theme tells the new one
+	EventThemeChanged
+)
+
+// Event is delivered on the Events channel when something happens in the tray application
+type Event struct {
+	Type EventType
+	// Item is the menu item the event relates to, it is nil for events not related to an item
+	Item *MenuItem
+	// ItemID is the id of Item
+	ItemID int32
+	// Checked is the checked state of Item when the event happened
+	Checked bool
+	// Menu is the menu that was opened or closed for EventMenuOpened and EventMenuClosed
+	Menu *Menu
+	// Gesture is how the tray icon was clicked for EventIconActivated
+	Gesture Gesture
+	// X and Y are the screen coordinates of the click for EventIconActivated
+	X, Y int
+	// Session is the change for EventSession
+	Session SessionEvent
+	// Theme is the new theme for EventThemeChanged
+	Theme Theme
+	// Reason is why the tray exited for EventExit
+	Reason ExitReason
+	// Err is the error returned by RunContext for EventExit
+	Err error
+}
+
+// Events returns a channel on which all tray events are delivered, alongside any callbacks.
+// Sending never blocks the tray: when the buffer is full new events are dropped and counted in DroppedEvents,
+// except for EventExit which replaces the oldest buffered event so it is always delivered
+func (t *Tray) Events() <-chan Event {
+	t.eventsLock.Lock()
+	defer t.eventsLock.Unlock()
+
+	if t.events == nil {
+		t.events = make(chan Event, t.eventBuffer)
+	}
+
+	return t.events
+}
+
+// SetEventBuffer will set how many events the Events channel can buffer. It must be called before the first call to Events
+func (t *Tray) SetEventBuffer(size int) {
+	if size < 1 {
+		size = 1
+	}
+
+	t.eventsLock.Lock()
+	defer t.eventsLock.Unlock()
+	t.eventBuffer = size
+}
+
+// DroppedEvents returns how many events were dropped because the Events channel was full
+func (t *Tray) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&t.droppedEvents)
+}
+
+func (t *Tray) sendEvent(event Event) {
+	t.eventsLock.Lock()
+	defer t.eventsLock.Unlock()
+
+	// Nobody has asked for events
+	if t.events == nil {
+		return
+	}
+
+	select {
+	case t.events <- event:
+		return
+	default:
+	}
+
+	if event.Type != EventExit {
+		atomic.AddUint64(&t.droppedEvents, 1)
+		return
+	}
+
+	// Make room for the exit event, the receiver may be draining concurrently so keep trying until it fits
+	for {
+		select {
+		case <-t.events:
+			atomic.AddUint64(&t.droppedEvents, 1)
+		default:
+		}
+
+		select {
+		case t.events <- event:
+			return
+		default:
+		}
+	}
+}
+
+func (t *Tray) sendItemEvent(eventType EventType, item *MenuItem) {
+	t.sendEvent(Event{
+		Type:    eventType,
+		Item:    item,
+		ItemID:  item.GetID(),
+		Checked: item.IsChecked(),
+	})
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"testing"
+)
+
+func TestFullEventsKeepExit(t *testing.T) {
+	tray, stop := startTestTray(t, Options{EventBuffer: 2})
+	events := tray.Events()
+
+	// Nobody receives, so clicks beyond the buffer are dropped without blocking the loop
+	for i := 0; i < 5; i++ {
+		tray.fakeClick(GestureSecondary, i, 0)
+	}
+	handled := make(chan struct{})
+	tray.native.post(func() {
+		close(handled)
+	})
+	waitFor(t, handled, "the clicks to be handled")
+	if dropped := tray.DroppedEvents(); dropped != 3 {
+		t.Errorf("%d events were dropped, want 3", dropped)
+	}
+
+	// The exit replaces the oldest event
+	stop()
+	if dropped := tray.DroppedEvents(); dropped != 4 {
+		t.Errorf("%d events were dropped after the exit, want 4", dropped)
+	}
+
+	first, last := <-events, <-events
+	if first.Type != EventIconActivated || first.X != 1 {
+		t.Errorf("first buffered event is %+v, want the second click", first)
+	}
+	if last.Type != EventExit || last.Reason != ExitCancelled {
+		t.Errorf("last buffered event is %+v, want the exit", last)
+	}
+}
+
+func TestItemEventsCarryState(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+	events := tray.Events()
+
+	item, err := tray.AddMenuItem("Item", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tray.ToggleItemChecked(item); err != nil {
+		t.Fatal(err)
+	}
+	tray.native.post(func() {
+		tray.onMenuItemSelected(item.GetID())
+	})
+
+	for _, want := range []EventType{EventCheckedChanged, EventItemClicked} {
+		event := <-events
+		if event.Type != want || event.Item != item || event.ItemID != item.GetID() || !event.Checked {
+			t.Errorf("got %+v, want event %v for the checked item", event, want)
+		}
+	}
+}
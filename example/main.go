@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"syscall"
 
 	"github.com/reefbarman/systray"
 	"github.com/reefbarman/systray/example/icon"
@@ -10,9 +12,20 @@ import (
 )
 
 func main() {
+	// Remove the icon when the example is interrupted from the console
+	systray.HandleSignals(os.Interrupt, syscall.SIGTERM)
+
 	systray.Run(func() {
 		systray.SetIcon(icon.Data)
 		systray.SetTooltip("This here is an example")
+
+		defaultItem := systray.AddMenuItem("Say Hello (double click icon)", func(item *systray.MenuItem) {
+			fmt.Println("Hello!")
+		})
+		if defaultItem != nil {
+			defaultItem.SetDefault(true)
+		}
+
 		subMenu := systray.AddSubMenuItem("Sub Menu")
 		if subMenu != nil {
 			subMenu.AddMenuItem("Click Me", func(item *systray.MenuItem) {
@@ -61,7 +74,7 @@ func main() {
 			systray.Quit()
 		})
 
-		<-systray.OnExitChan
+		<-systray.Done()
 
 		fmt.Println("app exiting")
 	})
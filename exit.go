@@ -0,0 +1,307 @@
+package systray
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultExitTimeout is how long the before exit hooks can run unless changed with SetExitTimeout
+const DefaultExitTimeout = 5 * time.Second
+
+// ErrExitTimeout is passed to the error handler when the before exit hooks did not complete in time
+var ErrExitTimeout = errors.New("systray: before exit hooks timed out")
+
+// ExitReason describes why the tray application is exiting
+type ExitReason int
+
+const (
+	// ExitUserQuit is used when Quit was called, it is the only reason that can be vetoed
+	ExitUserQuit ExitReason = iota
+	// ExitSessionEnd is used when the user is logging out or the system is shutting down
+	ExitSessionEnd
+	// ExitSignal is used when the process received a signal passed to HandleSignals
+	ExitSignal
+	// ExitCancelled is used when the context passed to RunContext was cancelled
+	ExitCancelled
+	// ExitError is used when the tray failed to start or the platform failed while running
+	ExitError
+	// ExitHostDisappeared is used when the tray host restarted and the icon could not be shown again
+	ExitHostDisappeared
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitUserQuit:
+		return "user quit"
+	case ExitSessionEnd:
+		return "session end"
+	case ExitSignal:
+		return "signal"
+	case ExitCancelled:
+		return "cancelled"
+	case ExitError:
+		return "error"
+	case ExitHostDisappeared:
+		return "host disappeared"
+	default:
+		return "unknown"
+	}
+}
+
+// Done returns a channel that is closed once the tray application has shut down and released everything the platform handed out.
+// Any number of goroutines can wait on it, including after the tray has already exited.
+// When the tray is run again a new channel is returned for that run
+func (t *Tray) Done() <-chan struct{} {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+	return t.done
+}
+
+// OnExit will register a callback to be run on the loop thread once the tray application has shut down.
+// If the tray has already exited the callback is run immediately on the calling goroutine, as the loop thread is gone
+func (t *Tray) OnExit(f func()) {
+	t.exitLock.Lock()
+	if t.exited {
+		t.exitLock.Unlock()
+		t.safeCall(f)
+		return
+	}
+
+	t.exitCallbacks = append(t.exitCallbacks, f)
+	t.exitLock.Unlock()
+}
+
+// OnBeforeExit will register a hook run before the tray application shuts down, while the icon is still shown.
+// Hooks can flush state and return false to veto the exit, which is only honoured for ExitUserQuit.
+// Hooks run one after another on their own goroutine and must complete within the exit timeout, see SetExitTimeout
+func (t *Tray) OnBeforeExit(f func(reason ExitReason) bool) {
+	t.hooksLock.Lock()
+	defer t.hooksLock.Unlock()
+	t.beforeExitHooks = append(t.beforeExitHooks, f)
+}
+
+// SetExitTimeout will set how long the hooks registered with OnBeforeExit can run in total before the tray exits regardless
+func (t *Tray) SetExitTimeout(timeout time.Duration) {
+	t.hooksLock.Lock()
+	defer t.hooksLock.Unlock()
+	t.exitTimeout = timeout
+}
+
+// requestExit runs the before exit hooks and closes the tray unless they vetoed the exit, returning false for a veto
+func (t *Tray) requestExit(reason ExitReason, err error) bool {
+	t.lockExitRequest()
+	defer func() {
+		<-t.exitRequest
+	}()
+
+	// Already closed by an earlier request
+	if t.checkRunning() != nil {
+		return true
+	}
+
+	if !t.runBeforeExit(reason) {
+		return false
+	}
+
+	t.closeTray(reason, err)
+	return true
+}
+
+// lockExitRequest waits for other exit requests to finish. On the loop thread queued changes keep running while waiting,
+// as the hooks of the other request may be waiting for them
+func (t *Tray) lockExitRequest() {
+	if !t.isLoopThread() {
+		t.exitRequest <- struct{}{}
+		return
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case t.exitRequest <- struct{}{}:
+			return
+		case <-ticker.C:
+			t.drainQueue()
+		}
+	}
+}
+
+// runBeforeExit runs the before exit hooks, returning false if the exit was vetoed
+func (t *Tray) runBeforeExit(reason ExitReason) bool {
+	t.hooksLock.RLock()
+	hooks := t.beforeExitHooks
+	timeout := t.exitTimeout
+	t.hooksLock.RUnlock()
+
+	if len(hooks) == 0 {
+		return true
+	}
+
+	result := make(chan bool, 1)
+	go func() {
+		proceed := true
+		for _, hook := range hooks {
+			hook := hook
+			t.safeCall(func() {
+				if !hook(reason) {
+					proceed = false
+				}
+			})
+		}
+		result <- proceed
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Hooks may change the tray while the loop thread is blocked waiting for them, so keep running queued changes
+	var pump <-chan time.Time
+	if t.isLoopThread() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		pump = ticker.C
+	}
+
+	for {
+		select {
+		case proceed := <-result:
+			return proceed || reason != ExitUserQuit
+		case <-timer.C:
+			t.reportError(ErrExitTimeout)
+			return true
+		case <-pump:
+			t.drainQueue()
+		}
+	}
+}
+
+// closeTray will start shutting down the tray, recording why it is exiting
+func (t *Tray) closeTray(reason ExitReason, err error) {
+	t.setExit(reason, err)
+	atomic.StoreInt32(&t.state, stateClosed)
+	t.quit()
+}
+
+// setExit records why the tray is exiting, only the first reason is kept
+func (t *Tray) setExit(reason ExitReason, err error) {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+
+	if !t.exitSet {
+		t.exitReason = reason
+		t.exitErr = err
+		t.exitSet = true
+	}
+}
+
+// currentExit returns why the tray is exiting, a tray closed without a recorded reason was quit
+func (t *Tray) currentExit() (ExitReason, error) {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+
+	if !t.exitSet {
+		return ExitUserQuit, ErrQuit
+	}
+
+	return t.exitReason, t.exitErr
+}
+
+func (t *Tray) resetExit() {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+
+	t.exitSet = false
+	t.exitReason = ExitUserQuit
+	t.exitErr = nil
+
+	// Channels handed out for the previous run stay closed
+	if t.exited {
+		t.exited = false
+		t.done = make(chan struct{})
+	}
+}
+
+// onSessionEnd is called on the loop thread when the session is ending, the process can be terminated as soon as it returns
+func (t *Tray) onSessionEnd() {
+	t.runBeforeExit(ExitSessionEnd)
+	t.setExit(ExitSessionEnd, ErrSessionEnd)
+}
+
+// onHostDisappeared is called on the loop thread when the icon could not be added again after the tray host restarted
+func (t *Tray) onHostDisappeared(err error) {
+	t.reportError(fmt.Errorf("systray: unable to show the icon after the tray host restarted: %w", err))
+	go t.requestExit(ExitHostDisappeared, ErrHostDisappeared)
+}
+
+func (t *Tray) onTrayExit() {
+	reason, err := t.currentExit()
+
+	atomic.StoreInt32(&t.state, stateClosed)
+
+	t.exitLock.Lock()
+	if t.exited {
+		t.exitLock.Unlock()
+		return
+	}
+	t.exited = true
+	callbacks := t.exitCallbacks
+	t.exitCallbacks = nil
+	t.exitLock.Unlock()
+
+	for _, f := range callbacks {
+		t.safeCall(f)
+	}
+
+	t.sendEvent(Event{Type: EventExit, Reason: reason, Err: err})
+
+	if t.exitChan != nil {
+		select {
+		case t.exitChan <- true:
+			break
+		default:
+			break
+		}
+	}
+}
+
+// finishExit is called once the platform has torn the tray down and nothing runs on the loop thread anymore.
+// It closes Done, after which the tray can be run again
+func (t *Tray) finishExit() {
+	// The loop can return without the window reporting its exit, e.g. when it failed
+	t.onTrayExit()
+	t.stopHandlers()
+
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+
+	t.running = false
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+}
+
+// runInProgress reports whether a run has begun and Done has not been closed yet
+func (t *Tray) runInProgress() bool {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+	return t.running
+}
+
+// beginRun marks the tray as running, returning ErrAlreadyRunning when an earlier run has not finished
+func (t *Tray) beginRun() error {
+	t.exitLock.Lock()
+	defer t.exitLock.Unlock()
+
+	if t.running {
+		return ErrAlreadyRunning
+	}
+
+	t.running = true
+	return nil
+}
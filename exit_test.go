@@ -0,0 +1,101 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnExitRunsOnLoopThread(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+
+	onLoop := make(chan bool, 1)
+	tray.OnExit(func() {
+		onLoop <- tray.isLoopThread()
+	})
+	stop()
+
+	if !<-onLoop {
+		t.Error("callback registered while running did not run on the loop thread")
+	}
+
+	ran := false
+	tray.OnExit(func() {
+		ran = true
+	})
+	if !ran {
+		t.Error("callback registered after the exit did not run right away on the calling goroutine")
+	}
+}
+
+func TestQuitVetoed(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	veto := true
+	reasons := make(chan ExitReason, 2)
+	tray.OnBeforeExit(func(reason ExitReason) bool {
+		reasons <- reason
+		return !veto
+	})
+
+	if err := tray.Quit(); err != ErrExitVetoed {
+		t.Fatalf("Quit returned %v when a hook vetoed it, want ErrExitVetoed", err)
+	}
+	if reason := <-reasons; reason != ExitUserQuit {
+		t.Errorf("hook got reason %v, want %v", reason, ExitUserQuit)
+	}
+	select {
+	case <-tray.Done():
+		t.Fatal("tray exited after the quit was vetoed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	veto = false
+	if err := tray.Quit(); err != nil {
+		t.Fatalf("Quit: %v", err)
+	}
+	<-reasons
+	waitFor(t, tray.Done(), "the tray to exit")
+
+	if reason, err := tray.currentExit(); reason != ExitUserQuit || err != ErrQuit {
+		t.Errorf("tray exited with %v, %v, want %v, %v", reason, err, ExitUserQuit, ErrQuit)
+	}
+}
+
+func TestHostDisappearedEndsRun(t *testing.T) {
+	reported := make(chan error, 1)
+	tray := New(Options{
+		ErrorHandler: func(err error) {
+			reported <- err
+		},
+	})
+
+	reasons := make(chan ExitReason, 1)
+	tray.OnBeforeExit(func(reason ExitReason) bool {
+		reasons <- reason
+		return false
+	})
+
+	err := tray.RunContext(context.Background(), func() {
+		tray.fakeHostLost()
+	})
+	if err != ErrHostDisappeared {
+		t.Errorf("RunContext returned %v, want ErrHostDisappeared", err)
+	}
+	if reason := <-reasons; reason != ExitHostDisappeared {
+		t.Errorf("hook got reason %v, want %v", reason, ExitHostDisappeared)
+	}
+	select {
+	case err := <-reported:
+		if !strings.Contains(err.Error(), "notification area is gone") {
+			t.Errorf("reported %v, want the error of the platform", err)
+		}
+	default:
+		t.Error("the error of the platform was not reported")
+	}
+}
@@ -0,0 +1,92 @@
+package systray
+
+// Gesture identifies how the tray icon was clicked, gestures can be combined for SetMenuGestures
+type Gesture int
+
+const (
+	// GesturePrimary is a left click on the tray icon
+	GesturePrimary Gesture = 1 << iota
+	// GestureSecondary is a middle click on the tray icon
+	GestureSecondary
+	// GestureContext is a right click on the tray icon
+	GestureContext
+	// GestureDoubleClick is a left double click on the tray icon
+	GestureDoubleClick
+)
+
+// DefaultMenuGestures are the gestures opening the tray menu unless changed with SetMenuGestures
+const DefaultMenuGestures = GesturePrimary | GestureContext
+
+// OnActivate will set a callback run on the loop thread when the tray icon is left clicked, with the screen coordinates of the click
+func (t *Tray) OnActivate(f func(x, y int)) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.onActivate = f
+}
+
+// OnSecondaryActivate will set a callback run on the loop thread when the tray icon is middle clicked, with the screen coordinates of the click
+func (t *Tray) OnSecondaryActivate(f func(x, y int)) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.onSecondaryActivate = f
+}
+
+// OnDoubleClick will set a callback run on the loop thread when the tray icon is double clicked, with the screen coordinates of the click.
+// Once double clicks are handled left clicks are only reported after the double click time has passed
+func (t *Tray) OnDoubleClick(f func(x, y int)) {
+	t.callbacksLock.Lock()
+	t.onDoubleClick = f
+	t.callbacksLock.Unlock()
+
+	t.Do(t.updateDoubleClickDetection)
+}
+
+// SetMenuGestures will set which gestures open the tray menu, e.g. only GestureContext so a left click can be handled with OnActivate
+func (t *Tray) SetMenuGestures(gestures Gesture) {
+	t.callbacksLock.Lock()
+	t.menuGestures = gestures
+	t.callbacksLock.Unlock()
+
+	t.Do(t.updateDoubleClickDetection)
+}
+
+// updateDoubleClickDetection must be called on the loop thread whenever something starts or stops caring about double clicks
+func (t *Tray) updateDoubleClickDetection() {
+	t.callbacksLock.RLock()
+	enabled := t.onDoubleClick != nil || t.menuGestures&GestureDoubleClick != 0
+	t.callbacksLock.RUnlock()
+
+	t.setDoubleClickDetection(enabled || t.defaultItem != nil)
+}
+
+// onTrayClick is called on the loop thread when the tray icon is clicked
+func (t *Tray) onTrayClick(gesture Gesture, x, y int) {
+	t.callbacksLock.RLock()
+	showMenu := t.menuGestures&gesture != 0
+	var callback func(x, y int)
+	switch gesture {
+	case GesturePrimary:
+		callback = t.onActivate
+	case GestureSecondary:
+		callback = t.onSecondaryActivate
+	case GestureDoubleClick:
+		callback = t.onDoubleClick
+	}
+	t.callbacksLock.RUnlock()
+
+	t.sendEvent(Event{Type: EventIconActivated, Gesture: gesture, X: x, Y: y})
+
+	if gesture == GestureDoubleClick {
+		t.activateDefaultItem()
+	}
+
+	if callback != nil {
+		t.safeCall(func() {
+			callback(x, y)
+		})
+	}
+
+	if showMenu {
+		t.showTrayMenu()
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"testing"
+)
+
+func TestClicksAreRoutedByGesture(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+	events := tray.Events()
+
+	type click struct {
+		callback string
+		x, y     int
+	}
+	clicks := make(chan click, 4)
+	tray.OnActivate(func(x, y int) {
+		clicks <- click{"activate", x, y}
+	})
+	tray.OnSecondaryActivate(func(x, y int) {
+		clicks <- click{"secondary", x, y}
+	})
+
+	// handle delivers a click and waits for it to be handled, returning the callback it ran and whether the menu was shown
+	handle := func(gesture Gesture) (string, bool) {
+		t.Helper()
+
+		shown := tray.native.menusShown()
+		tray.fakeClick(gesture, 10, 20)
+		handled := make(chan struct{})
+		tray.native.post(func() {
+			close(handled)
+		})
+		waitFor(t, handled, "the click to be handled")
+
+		// Showing the menu sends its own events after the click
+		event := <-events
+		if event.Type != EventIconActivated || event.Gesture != gesture || event.X != 10 || event.Y != 20 {
+			t.Errorf("click %v was sent as %+v", gesture, event)
+		}
+		for len(events) > 0 {
+			<-events
+		}
+
+		callback := ""
+		select {
+		case c := <-clicks:
+			if c.x != 10 || c.y != 20 {
+				t.Errorf("%s callback got %d,%d, want 10,20", c.callback, c.x, c.y)
+			}
+			callback = c.callback
+		default:
+		}
+		return callback, tray.native.menusShown() > shown
+	}
+
+	for _, tc := range []struct {
+		gestures Gesture
+		gesture  Gesture
+		callback string
+		menu     bool
+	}{
+		{DefaultMenuGestures, GesturePrimary, "activate", true},
+		{DefaultMenuGestures, GestureSecondary, "secondary", false},
+		{DefaultMenuGestures, GestureContext, "", true},
+		{GestureContext, GesturePrimary, "activate", false},
+		{GestureContext, GestureContext, "", true},
+		{GestureSecondary, GestureSecondary, "secondary", true},
+		{GestureSecondary, GestureContext, "", false},
+	} {
+		tray.SetMenuGestures(tc.gestures)
+		callback, menu := handle(tc.gesture)
+		if callback != tc.callback || menu != tc.menu {
+			t.Errorf("menu gestures %b, click %v: ran %q and showed the menu %v, want %q and %v",
+				tc.gestures, tc.gesture, callback, menu, tc.callback, tc.menu)
+		}
+	}
+}
+
+func TestDoubleClickDetection(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	// detects waits for queued changes to run before asking the fake backend
+	detects := func() bool {
+		if err := tray.runOnLoop(func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return tray.native.detectsDoubleClicks()
+	}
+
+	if detects() {
+		t.Error("double clicks are detected before anything handles them, delaying every left click")
+	}
+
+	doubleClicked := make(chan struct{}, 1)
+	tray.OnDoubleClick(func(x, y int) {
+		doubleClicked <- struct{}{}
+	})
+	if !detects() {
+		t.Error("double clicks are not detected with an OnDoubleClick callback")
+	}
+	tray.fakeClick(GestureDoubleClick, 0, 0)
+	<-doubleClicked
+
+	tray.OnDoubleClick(nil)
+	if detects() {
+		t.Error("double clicks are still detected after the callback was removed")
+	}
+
+	tray.SetMenuGestures(GestureDoubleClick)
+	if !detects() {
+		t.Error("double clicks are not detected while they open the menu")
+	}
+}
@@ -3,7 +3,6 @@ module github.com/reefbarman/systray
 go 1.13
 
 require (
-	github.com/getlantern/golog v0.0.0-20190830074920-4ef2e798c2d7
 	github.com/sqweek/dialog v0.0.0-20190728103509-6254ed5b0d3c
 	golang.org/x/sys v0.0.0-20200212091648-12a6c2dcc1e4
 )
@@ -0,0 +1,225 @@
+package systray
+
+import (
+	"errors"
+)
+
+// DefaultHandlerQueueSize is the queue size used when HandlerOptions.QueueSize is not set
+const DefaultHandlerQueueSize = 64
+
+// ErrHandlerQueueFull is passed to the error handler when a click is dropped because too many handlers are waiting or running
+var ErrHandlerQueueFull = errors.New("systray: handler queue is full")
+
+// HandlerMode controls where the on click handlers of menu items are run
+type HandlerMode int
+
+const (
+	// HandlerInline runs handlers on the loop thread, the tray does not respond until the handler returns
+	HandlerInline HandlerMode = iota
+	// HandlerSerial runs handlers one at a time, in the order of the clicks, on a dedicated worker goroutine
+	HandlerSerial
+	// HandlerConcurrent runs every handler on its own goroutine
+	HandlerConcurrent
+)
+
+// RepeatPolicy controls what happens when an item is clicked again while its handler is still waiting or running
+type RepeatPolicy int
+
+const (
+	// RepeatQueue runs the handler once for every click
+	RepeatQueue RepeatPolicy = iota
+	// RepeatDrop ignores clicks on the item until its handler has completed
+	RepeatDrop
+	// RepeatCoalesce runs the handler once more after it completes, no matter how many clicks happened meanwhile
+	RepeatCoalesce
+)
+
+// HandlerOptions configures how the on click handlers of menu items are run
+type HandlerOptions struct {
+	Mode   HandlerMode
+	Repeat RepeatPolicy
+	// QueueSize bounds the number of clicks waiting for the serial worker or, in concurrent mode, the number of handlers running at once.
+	// Clicks beyond it are dropped and ErrHandlerQueueFull is passed to the error handler. Zero uses DefaultHandlerQueueSize.
+	// It is only used from the options set with SetHandlerOptions, as all items share the same worker and limit
+	QueueSize int
+}
+
+// SetHandlerOptions will set how on click handlers are run for all items that have not set their own options.
+// By default handlers are run inline on the loop thread
+func (t *Tray) SetHandlerOptions(opts HandlerOptions) {
+	t.handlerLock.Lock()
+	defer t.handlerLock.Unlock()
+
+	size := opts.queueSize()
+
+	// Queued and running handlers finish on the old worker and limit, new clicks use the new size.
+	// The worker started for the new size waits for the old one, so serial handlers never overlap
+	if t.serialQueue != nil && cap(t.serialQueue) != size {
+		close(t.serialQueue)
+		t.serialQueue = nil
+	}
+	if t.concurrentSlots != nil && cap(t.concurrentSlots) != size {
+		t.concurrentSlots = nil
+	}
+
+	t.handlerOptions = opts
+}
+
+// stopHandlers lets the serial worker exit once it has run the handlers still queued, a later run starts a new one
+func (t *Tray) stopHandlers() {
+	t.handlerLock.Lock()
+	defer t.handlerLock.Unlock()
+
+	if t.serialQueue != nil {
+		close(t.serialQueue)
+		t.serialQueue = nil
+	}
+}
+
+// SetHandlerOptions will set how the on click handler of this item is run, overriding the options set for its tray with SetHandlerOptions
+func (m *MenuItem) SetHandlerOptions(opts HandlerOptions) {
+	m.tray.handlerLock.Lock()
+	defer m.tray.handlerLock.Unlock()
+	m.handlerOptions = &opts
+}
+
+func (opts HandlerOptions) queueSize() int {
+	if opts.QueueSize <= 0 {
+		return DefaultHandlerQueueSize
+	}
+
+	return opts.QueueSize
+}
+
+// itemHandlerOptions must be called with handlerLock held
+func (t *Tray) itemHandlerOptions(item *MenuItem) HandlerOptions {
+	if item.handlerOptions != nil {
+		return *item.handlerOptions
+	}
+
+	return t.handlerOptions
+}
+
+// runHandler is called on the loop thread when an item is clicked
+func (t *Tray) runHandler(item *MenuItem) {
+	if item.onClick == nil {
+		return
+	}
+
+	t.handlerLock.Lock()
+	opts := t.itemHandlerOptions(item)
+
+	if opts.Mode == HandlerInline {
+		t.handlerLock.Unlock()
+		t.safeCall(func() {
+			item.onClick(item)
+		})
+		return
+	}
+
+	if item.handlersInFlight > 0 {
+		switch opts.Repeat {
+		case RepeatDrop:
+			t.handlerLock.Unlock()
+			return
+		case RepeatCoalesce:
+			item.handlerPending = true
+			t.handlerLock.Unlock()
+			return
+		}
+	}
+
+	err := t.submitHandler(item, opts.Mode)
+	t.handlerLock.Unlock()
+
+	if err != nil {
+		t.reportError(err)
+	}
+}
+
+// submitHandler must be called with handlerLock held
+func (t *Tray) submitHandler(item *MenuItem, mode HandlerMode) error {
+	run := func() {
+		t.safeCall(func() {
+			item.onClick(item)
+		})
+	}
+
+	switch mode {
+	case HandlerSerial:
+		if t.serialQueue == nil {
+			previous := t.serialDone
+			t.serialQueue = make(chan func(), t.handlerOptions.queueSize())
+			t.serialDone = make(chan struct{})
+			go handlerWorker(t.serialQueue, previous, t.serialDone)
+		}
+
+		job := func() {
+			run()
+			t.handlerCompleted(item)
+		}
+
+		select {
+		case t.serialQueue <- job:
+		default:
+			return ErrHandlerQueueFull
+		}
+	case HandlerConcurrent:
+		if t.concurrentSlots == nil {
+			t.concurrentSlots = make(chan struct{}, t.handlerOptions.queueSize())
+		}
+
+		slots := t.concurrentSlots
+		select {
+		case slots <- struct{}{}:
+			go func() {
+				run()
+				<-slots
+				t.handlerCompleted(item)
+			}()
+		default:
+			return ErrHandlerQueueFull
+		}
+	}
+
+	item.handlersInFlight++
+	return nil
+}
+
+// handlerCompleted is called once a handler has run, running it again if clicks were coalesced meanwhile
+func (t *Tray) handlerCompleted(item *MenuItem) {
+	t.handlerLock.Lock()
+	item.handlersInFlight--
+
+	var err error
+	if item.handlerPending && item.handlersInFlight == 0 {
+		item.handlerPending = false
+
+		// The options can have changed since the handler was submitted
+		if mode := t.itemHandlerOptions(item).Mode; mode == HandlerInline {
+			t.Do(func() {
+				item.onClick(item)
+			})
+		} else {
+			err = t.submitHandler(item, mode)
+		}
+	}
+	t.handlerLock.Unlock()
+
+	if err != nil {
+		t.reportError(err)
+	}
+}
+
+// handlerWorker runs the jobs of a serial queue once the worker of the previous queue has completed, closing done when the queue is closed
+func handlerWorker(queue chan func(), previous <-chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	if previous != nil {
+		<-previous
+	}
+
+	for job := range queue {
+		job()
+	}
+}
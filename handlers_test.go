@@ -0,0 +1,97 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Changing the queue size replaces the serial worker, handlers queued on the old one must still run first and never overlap
+func TestSerialHandlersKeepOrderWhenQueueChanges(t *testing.T) {
+	tray, stop := startTestTray(t, Options{Handlers: HandlerOptions{Mode: HandlerSerial}})
+	defer stop()
+
+	gate := make(chan struct{})
+	started := make(chan string, 3)
+	var lock sync.Mutex
+	var order []string
+	running, overlapped := 0, false
+	var wg sync.WaitGroup
+
+	handler := func(name string, wait bool) func(*MenuItem) {
+		return func(*MenuItem) {
+			defer wg.Done()
+
+			lock.Lock()
+			running++
+			if running > 1 {
+				overlapped = true
+			}
+			lock.Unlock()
+
+			started <- name
+			if wait {
+				<-gate
+			}
+
+			lock.Lock()
+			running--
+			order = append(order, name)
+			lock.Unlock()
+		}
+	}
+
+	first, err := tray.AddMenuItem("First", handler("first", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tray.AddMenuItem("Second", handler("second", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	click := func(item *MenuItem) {
+		wg.Add(1)
+		if err := tray.runOnLoop(func() error {
+			tray.onMenuItemSelected(item.GetID())
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	click(first)
+	click(first)
+	if name := <-started; name != "first" {
+		t.Fatalf("%s started first", name)
+	}
+
+	tray.SetHandlerOptions(HandlerOptions{Mode: HandlerSerial, QueueSize: 8})
+	click(second)
+
+	// The first handler is still blocked, so nothing else may start meanwhile
+	select {
+	case name := <-started:
+		t.Errorf("%s started while the first handler was running", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(gate)
+	wg.Wait()
+
+	want := []string{"first", "first", "second"}
+	if len(order) != len(want) {
+		t.Fatalf("handlers ran as %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("handlers ran as %v, want %v", order, want)
+		}
+	}
+	if overlapped {
+		t.Error("serial handlers ran at the same time")
+	}
+}
@@ -0,0 +1,346 @@
+package systray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// icoSizes are the sizes written into .ico files created from images, covering the small icon size at up to 400% scaling
+var icoSizes = []int{16, 20, 24, 32, 40, 48, 64}
+
+// encodeICO will create an .ico file holding the images at each of the sizes.
+// Each size is scaled down from the smallest image at least as large, so an image drawn at one of the sizes is used as it is
+func encodeICO(imgs []image.Image, sizes []int) ([]byte, error) {
+	images := make([]image.Image, len(sizes))
+	for i, size := range sizes {
+		images[i] = scaleImage(pickImage(imgs, size), size)
+	}
+
+	return encodeICOImages(images)
+}
+
+// imageSize is the size of the square an image fills, its larger side
+func imageSize(img image.Image) int {
+	bounds := img.Bounds()
+	if bounds.Dx() > bounds.Dy() {
+		return bounds.Dx()
+	}
+
+	return bounds.Dy()
+}
+
+// pickImage returns the smallest image at least size pixels large, or the largest image when none is
+func pickImage(imgs []image.Image, size int) image.Image {
+	best := imgs[0]
+	for _, img := range imgs[1:] {
+		bestSize, imgSize := imageSize(best), imageSize(img)
+		switch {
+		case bestSize < size:
+			if imgSize > bestSize {
+				best = img
+			}
+		case imgSize >= size && imgSize < bestSize:
+			best = img
+		}
+	}
+
+	return best
+}
+
+// encodeICOImages will create an .ico file holding the images at their own sizes.
+// The entries are stored as PNG, which Windows has been able to load since Vista
+func encodeICOImages(images []image.Image) ([]byte, error) {
+	entries := make([][]byte, len(images))
+	for i, img := range images {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		entries[i] = buf.Bytes()
+	}
+
+	// https://docs.microsoft.com/en-us/previous-versions/ms997538(v=msdn.10)
+	var ico bytes.Buffer
+	header := struct {
+		Reserved uint16
+		Type     uint16
+		Count    uint16
+	}{
+		Type:  1,
+		Count: uint16(len(entries)),
+	}
+	binary.Write(&ico, binary.LittleEndian, header)
+
+	offset := uint32(6 + 16*len(entries))
+	for i, img := range images {
+		entry := struct {
+			Width      uint8
+			Height     uint8
+			ColorCount uint8
+			Reserved   uint8
+			Planes     uint16
+			BitCount   uint16
+			BytesInRes uint32
+			Offset     uint32
+		}{
+			Width:      icoDirectorySize(img.Bounds().Dx()),
+			Height:     icoDirectorySize(img.Bounds().Dy()),
+			Planes:     1,
+			BitCount:   32,
+			BytesInRes: uint32(len(entries[i])),
+			Offset:     offset,
+		}
+		binary.Write(&ico, binary.LittleEndian, entry)
+		offset += entry.BytesInRes
+	}
+
+	for _, data := range entries {
+		ico.Write(data)
+	}
+
+	return ico.Bytes(), nil
+}
+
+// icoDirectorySize returns how a size is stored in the .ico directory, where 0 stands for 256 pixels
+func icoDirectorySize(size int) uint8 {
+	if size >= 256 {
+		return 0
+	}
+
+	return uint8(size)
+}
+
+// icoEntry is a single image in an .ico file
+type icoEntry struct {
+	Width    int
+	Height   int
+	BitCount int
+	// Data is the PNG or DIB data of the image
+	Data []byte
+}
+
+// decodeICO will read the directory of an .ico file, the entries share the data of the file
+func decodeICO(data []byte) ([]icoEntry, error) {
+	if len(data) < 6 {
+		return nil, errors.New("too short for an .ico header")
+	}
+
+	r := bytes.NewReader(data)
+	var header struct {
+		Reserved uint16
+		Type     uint16
+		Count    uint16
+	}
+	binary.Read(r, binary.LittleEndian, &header)
+
+	if header.Reserved != 0 || header.Type != 1 {
+		return nil, errors.New("not an .ico file")
+	}
+	if header.Count == 0 {
+		return nil, errors.New(".ico file has no images")
+	}
+	if len(data) < 6+16*int(header.Count) {
+		return nil, errors.New("truncated .ico directory")
+	}
+
+	entries := make([]icoEntry, header.Count)
+	for i := range entries {
+		var entry struct {
+			Width      uint8
+			Height     uint8
+			ColorCount uint8
+			Reserved   uint8
+			Planes     uint16
+			BitCount   uint16
+			BytesInRes uint32
+			Offset     uint32
+		}
+		binary.Read(r, binary.LittleEndian, &entry)
+
+		start, end := uint64(entry.Offset), uint64(entry.Offset)+uint64(entry.BytesInRes)
+		if entry.BytesInRes == 0 || end > uint64(len(data)) {
+			return nil, fmt.Errorf("image %d of .ico file is out of bounds", i)
+		}
+
+		entries[i] = icoEntry{
+			Width:    icoDimension(entry.Width),
+			Height:   icoDimension(entry.Height),
+			BitCount: int(entry.BitCount),
+			Data:     data[start:end],
+		}
+	}
+
+	return entries, nil
+}
+
+func icoDimension(d uint8) int {
+	if d == 0 {
+		return 256
+	}
+
+	return int(d)
+}
+
+// pickICOEntry returns the entry best suited to be shown at size: the smallest at least that large,
+// so it only has to be scaled down, or the largest when all are smaller. Ties go to the entry with more colours
+func pickICOEntry(entries []icoEntry, size int) icoEntry {
+	best := entries[0]
+	for _, entry := range entries[1:] {
+		switch {
+		case entry.Width == best.Width:
+			if entry.BitCount > best.BitCount {
+				best = entry
+			}
+		case best.Width < size:
+			if entry.Width > best.Width {
+				best = entry
+			}
+		case entry.Width >= size && entry.Width < best.Width:
+			best = entry
+		}
+	}
+
+	return best
+}
+
+// resampleICOEntry will scale the image of an entry to size pixels, returned as PNG data which can be used as the data of an entry
+func resampleICOEntry(entry icoEntry, size int) ([]byte, error) {
+	img, err := decodeICOImage(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, size)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeICOImage will decode the image of an entry, which is either PNG or an uncompressed DIB with an AND mask
+func decodeICOImage(entry icoEntry) (image.Image, error) {
+	if bytes.HasPrefix(entry.Data, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(entry.Data))
+	}
+
+	return decodeDIB(entry.Data)
+}
+
+// decodeDIB will decode the BITMAPINFOHEADER, pixels and AND mask of an icon image.
+// https://docs.microsoft.com/en-us/windows/win32/api/wingdi/ns-wingdi-bitmapinfoheader
+func decodeDIB(data []byte) (image.Image, error) {
+	var header struct {
+		Size          uint32
+		Width         int32
+		Height        int32
+		Planes        uint16
+		BitCount      uint16
+		Compression   uint32
+		SizeImage     uint32
+		XPelsPerMeter int32
+		YPelsPerMeter int32
+		ClrUsed       uint32
+		ClrImportant  uint32
+	}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		return nil, errors.New("truncated bitmap header")
+	}
+	// The size is checked before it is used as an offset, as an int it can be negative on 32 bit platforms
+	if header.Size < 40 || uint64(header.Size) > uint64(len(data)) {
+		return nil, fmt.Errorf("bitmap header size %d is out of range", header.Size)
+	}
+	if header.Compression != 0 {
+		return nil, errors.New("compressed bitmaps are not supported")
+	}
+
+	// The height covers both the pixels and the AND mask
+	width, height := int(header.Width), int(header.Height)/2
+	if width <= 0 || height <= 0 || width > 256 || height > 256 {
+		return nil, fmt.Errorf("bitmap size %dx%d is out of range", width, height)
+	}
+
+	bitCount := int(header.BitCount)
+	var palette []color.NRGBA
+	offset := int(header.Size)
+	switch bitCount {
+	case 1, 4, 8:
+		colors := uint64(header.ClrUsed)
+		if colors == 0 {
+			colors = 1 << uint(bitCount)
+		}
+		if uint64(len(data)-offset) < 4*colors {
+			return nil, errors.New("truncated bitmap palette")
+		}
+		palette = make([]color.NRGBA, colors)
+		for i := range palette {
+			p := data[offset+4*i:]
+			palette[i] = color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+		}
+		offset += 4 * int(colors)
+	case 24, 32:
+	default:
+		return nil, fmt.Errorf("%d bit bitmaps are not supported", bitCount)
+	}
+
+	stride := (width*bitCount + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	maskOffset := offset + stride*height
+	if len(data) < maskOffset {
+		return nil, errors.New("truncated bitmap pixels")
+	}
+	// The AND mask is only read when all of it is there
+	hasMask := len(data)-maskOffset >= maskStride*height
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		// Rows are stored bottom up
+		row := data[offset+(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bitCount {
+			case 32:
+				c = color.NRGBA{R: row[4*x+2], G: row[4*x+1], B: row[4*x], A: row[4*x+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			case 24:
+				c = color.NRGBA{R: row[3*x+2], G: row[3*x+1], B: row[3*x], A: 0xff}
+			default:
+				bit := x * bitCount
+				index := int(row[bit/8]>>uint(8-bitCount-bit%8)) & (1<<uint(bitCount) - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// Without an alpha channel transparency comes from the AND mask, where a set bit is transparent
+	if hasAlpha || !hasMask {
+		if !hasAlpha && bitCount == 32 {
+			for i := 3; i < len(img.Pix); i += 4 {
+				img.Pix[i] = 0xff
+			}
+		}
+		return img, nil
+	}
+
+	for y := 0; y < height; y++ {
+		row := data[maskOffset+(height-1-y)*maskStride:]
+		for x := 0; x < width; x++ {
+			if row[x/8]&(0x80>>uint(x%8)) != 0 {
+				img.Pix[img.PixOffset(x, y)+3] = 0
+			} else if bitCount == 32 {
+				img.Pix[img.PixOffset(x, y)+3] = 0xff
+			}
+		}
+	}
+
+	return img, nil
+}
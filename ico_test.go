@@ -0,0 +1,291 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// icoWithEntry returns an .ico file holding a single 16 pixel entry with the data, which is not checked
+func icoWithEntry(data []byte) []byte {
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, [3]uint16{0, 1, 1})
+	binary.Write(&ico, binary.LittleEndian, struct {
+		Width, Height, ColorCount, Reserved uint8
+		Planes, BitCount                    uint16
+		BytesInRes, Offset                  uint32
+	}{Width: 16, Height: 16, Planes: 1, BitCount: 32, BytesInRes: uint32(len(data)), Offset: 6 + 16})
+	ico.Write(data)
+	return ico.Bytes()
+}
+
+func TestICORoundTrip(t *testing.T) {
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+	data, err := encodeICO([]image.Image{img}, icoSizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := decodeICO(data)
+	if err != nil {
+		t.Fatalf("decodeICO: %v", err)
+	}
+	if len(entries) != len(icoSizes) {
+		t.Fatalf("decoded %d entries, want one for each of the %d sizes", len(entries), len(icoSizes))
+	}
+
+	for i, entry := range entries {
+		size := icoSizes[i]
+		if entry.Width != size || entry.Height != size || entry.BitCount != 32 {
+			t.Errorf("entry %d is %dx%d at %d bits, want %dx%d at 32 bits", i, entry.Width, entry.Height, entry.BitCount, size, size)
+		}
+
+		decoded, err := decodeICOImage(entry)
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if decoded.Bounds() != image.Rect(0, 0, size, size) {
+			t.Errorf("entry %d decoded to %v, want %dx%d", i, decoded.Bounds(), size, size)
+		}
+		if c := color.NRGBAModel.Convert(decoded.At(size/2, size/2)); c != red {
+			t.Errorf("entry %d has %v at its centre, want %v", i, c, red)
+		}
+	}
+}
+
+func TestPickImage(t *testing.T) {
+	imgs := []image.Image{
+		image.NewNRGBA(image.Rect(0, 0, 32, 32)),
+		image.NewNRGBA(image.Rect(0, 0, 16, 16)),
+		image.NewNRGBA(image.Rect(0, 0, 256, 256)),
+	}
+
+	tests := []struct {
+		size int
+		want int
+	}{
+		{16, 16},
+		// At 125% and 150% scaling the 32 pixel image is scaled down rather than the 16 pixel one up
+		{20, 32},
+		{24, 32},
+		{32, 32},
+		{40, 256},
+		{512, 256},
+	}
+	for _, test := range tests {
+		if got := imageSize(pickImage(imgs, test.size)); got != test.want {
+			t.Errorf("size %d picked the %d pixel image, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestEncodeICOScalesEachSize(t *testing.T) {
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	small, large := image.NewNRGBA(image.Rect(0, 0, 16, 16)), image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(small, small.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(large, large.Bounds(), image.NewUniform(blue), image.Point{}, draw.Src)
+
+	data, err := encodeICO([]image.Image{large, small}, icoSizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeICO(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the small icon size at 100% uses the image drawn for it, every higher DPI is scaled down from the large one
+	for _, entry := range entries {
+		img, err := decodeICOImage(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := blue
+		if entry.Width == 16 {
+			want = red
+		}
+		if c := color.NRGBAModel.Convert(img.At(entry.Width/2, entry.Width/2)); c != want {
+			t.Errorf("the %d pixel entry is %v, want %v", entry.Width, c, want)
+		}
+	}
+}
+
+func TestDecodeICORejectsBadFiles(t *testing.T) {
+	valid, err := encodeICOImages([]image.Image{image.NewNRGBA(image.Rect(0, 0, 16, 16))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outOfBounds := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(outOfBounds[6+12:], uint32(len(valid)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:4]},
+		{"not an icon", []byte("\x89PNG\r\n\x1a\n")},
+		{"no images", []byte{0, 0, 1, 0, 0, 0}},
+		{"truncated directory", valid[:6+8]},
+		{"image out of bounds", outOfBounds},
+	}
+	for _, test := range tests {
+		if _, err := decodeICO(test.data); err == nil {
+			t.Errorf("%s: decodeICO succeeded", test.name)
+		}
+	}
+}
+
+func TestPickICOEntry(t *testing.T) {
+	entries := []icoEntry{
+		{Width: 16, Height: 16, BitCount: 32},
+		{Width: 32, Height: 32, BitCount: 8},
+		{Width: 32, Height: 32, BitCount: 32},
+		{Width: 48, Height: 48, BitCount: 32},
+	}
+
+	tests := []struct {
+		size      int
+		wantWidth int
+	}{
+		{16, 16},
+		// The smallest entry at least as large is scaled down rather than a smaller one up
+		{20, 32},
+		{32, 32},
+		{40, 48},
+		// When every entry is smaller the largest is used
+		{64, 48},
+	}
+	for _, test := range tests {
+		got := pickICOEntry(entries, test.size)
+		if got.Width != test.wantWidth {
+			t.Errorf("size %d picked the %d pixel entry, want %d", test.size, got.Width, test.wantWidth)
+		}
+		if got.Width == 32 && got.BitCount != 32 {
+			t.Errorf("size %d picked the %d bit entry over the 32 bit one", test.size, got.BitCount)
+		}
+	}
+}
+
+func TestResampleICOEntry(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 48))
+	data, err := encodeICOImages([]image.Image{img})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeICO(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resampled, err := resampleICOEntry(entries[0], 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(resampled, []byte("\x89PNG")) {
+		t.Fatal("the resampled entry is not PNG data")
+	}
+
+	decoded, err := decodeICOImage(icoEntry{Data: resampled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != image.Rect(0, 0, 20, 20) {
+		t.Errorf("resampled to %v, want 20x20", decoded.Bounds())
+	}
+}
+
+// dibHeader is a BITMAPINFOHEADER for a 16 pixel icon, with the height covering the AND mask
+func dibHeader(bitCount uint16) []byte {
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, struct {
+		Size          uint32
+		Width         int32
+		Height        int32
+		Planes        uint16
+		BitCount      uint16
+		Compression   uint32
+		SizeImage     uint32
+		XPelsPerMeter int32
+		YPelsPerMeter int32
+		ClrUsed       uint32
+		ClrImportant  uint32
+	}{Size: 40, Width: 16, Height: 32, Planes: 1, BitCount: bitCount})
+	return header.Bytes()
+}
+
+func TestDecodeDIB(t *testing.T) {
+	// 32 bit pixels without alpha take their transparency from the AND mask, which has the left half set
+	data := dibHeader(32)
+	for i := 0; i < 16*16; i++ {
+		data = append(data, 0xff, 0, 0, 0)
+	}
+	for i := 0; i < 16; i++ {
+		data = append(data, 0xff, 0, 0, 0)
+	}
+
+	img, err := decodeDIB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBAModel.Convert(img.At(3, 5)); c != (color.NRGBA{B: 0xff}) {
+		t.Errorf("masked pixel is %v, want transparent blue", c)
+	}
+	if c := color.NRGBAModel.Convert(img.At(12, 5)); c != (color.NRGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("unmasked pixel is %v, want opaque blue", c)
+	}
+}
+
+func TestDecodeDIBRejectsMalformedBitmaps(t *testing.T) {
+	withSize := func(size uint32, length int) []byte {
+		data := make([]byte, length)
+		copy(data, dibHeader(32))
+		binary.LittleEndian.PutUint32(data, size)
+		return data
+	}
+	withColors := func(colors uint32) []byte {
+		data := append(dibHeader(8), make([]byte, 1024)...)
+		binary.LittleEndian.PutUint32(data[32:], colors)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated header", dibHeader(32)[:20]},
+		{"header size too small", withSize(12, 0x468)},
+		// As an int the size is negative on 32 bit platforms, where it used to be taken as the offset of the pixels
+		{"header size beyond the data", withSize(0xfffffff0, 0x468)},
+		{"palette beyond the data", withColors(0xffffffff)},
+		{"truncated pixels", withSize(40, 40+16*16*4-1)},
+	}
+	for _, test := range tests {
+		img, err := decodeDIB(test.data)
+		if err == nil {
+			t.Errorf("%s: decoded a %v bitmap", test.name, img.Bounds())
+		}
+	}
+
+	// Drawing a badge decodes every entry, a malformed one is reported as an invalid icon
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+	if err := tray.SetBadge("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tray.SetIcon(icoWithEntry(withSize(0xfffffff0, 0x468))); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("SetIcon with a malformed bitmap returned %v, want ErrInvalidIcon", err)
+	}
+}
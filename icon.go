@@ -0,0 +1,70 @@
+package systray
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+// SetIconImage will set the icon for the tray from an image, converting it to the format the platform needs at the sizes it uses
+func (t *Tray) SetIconImage(img image.Image) error {
+	return t.SetIconImages(img)
+}
+
+// SetIconImages will set the icon for the tray from the same icon drawn at several sizes, e.g. 16, 32 and 256 pixels.
+// Each size the platform uses is scaled down from the smallest image at least as large, so small sizes can be hand drawn
+// while a single large image also works. The icon is shown again at the right size when the DPI changes
+func (t *Tray) SetIconImages(imgs ...image.Image) error {
+	if len(imgs) == 0 {
+		return fmt.Errorf("%w: no images", ErrInvalidIcon)
+	}
+	for _, img := range imgs {
+		if img == nil || img.Bounds().Empty() {
+			return fmt.Errorf("%w: empty image", ErrInvalidIcon)
+		}
+	}
+
+	iconBytes, err := encodeNativeIcon(imgs...)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+
+	return t.SetIcon(iconBytes)
+}
+
+// SetIconPNG will set the icon for the tray from PNG data, converting it like SetIconImage
+func (t *Tray) SetIconPNG(pngBytes []byte) error {
+	img, err := png.Decode(bytes.NewReader(pngBytes))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+
+	return t.SetIconImage(img)
+}
+
+// SetIconName will show the fallback for the named icon, which is in the format SetIcon takes, as Windows has no icon themes
+func (t *Tray) SetIconName(name string, fallback []byte) error {
+	if err := validateIcon(fallback); err != nil {
+		return fmt.Errorf("icon %q: %w", name, err)
+	}
+
+	return t.runOnLoop(func() error {
+		t.iconSet = nil
+		t.iconName = name
+		return t.useIcon(fallback)
+	})
+}
+
+// IconName returns the name of the icon set with SetIconName, it is empty when the icon was set another way
+func (t *Tray) IconName() string {
+	var name string
+	if err := t.runOnLoop(func() error {
+		name = t.iconName
+		return nil
+	}); err != nil {
+		return ""
+	}
+
+	return name
+}
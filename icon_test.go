@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"testing"
+)
+
+func TestSetIconNameShowsFallback(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	fallback := testIcon(t, color.White)
+	if err := tray.SetIconName("test-app", fallback); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tray.native.shownIcon(), fallback) {
+		t.Error("the fallback is not shown")
+	}
+	if name := tray.IconName(); name != "test-app" {
+		t.Errorf("IconName is %q, want test-app", name)
+	}
+
+	if err := tray.SetIconName("missing", nil); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("SetIconName without a fallback returned %v, want ErrInvalidIcon", err)
+	}
+	if name := tray.IconName(); name != "test-app" {
+		t.Errorf("a failed SetIconName changed the name to %q", name)
+	}
+
+	if err := tray.SetIcon(testIcon(t, color.Black)); err != nil {
+		t.Fatal(err)
+	}
+	if name := tray.IconName(); name != "" {
+		t.Errorf("IconName is %q after setting an icon", name)
+	}
+
+	// Menu items keep the name the same way
+	item, err := tray.AddMenuItem("Item", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := item.SetIconName("test-app", fallback); err != nil {
+		t.Fatal(err)
+	}
+	if item.GetIcon() == nil || item.GetIconName() != "test-app" {
+		t.Error("the menu item does not show the fallback of its named icon")
+	}
+	if err := item.SetIconName("missing", nil); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("SetIconName without a fallback returned %v, want ErrInvalidIcon", err)
+	}
+	if err := item.SetIconName("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if item.GetIcon() != nil || item.GetIconName() != "" {
+		t.Error("an empty name and fallback did not remove the icon")
+	}
+}
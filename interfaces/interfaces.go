@@ -1,5 +1,7 @@
 package interfaces
 
+import "image"
+
 type MenuItem interface {
 	GetID() int32
 	GetTitle() string
@@ -7,6 +9,16 @@ type MenuItem interface {
 	IsDisabled() bool
 }
 
+// MenuItemDefault is implemented by menu items which can be the default item of their menu
+type MenuItemDefault interface {
+	IsDefault() bool
+}
+
+// MenuItemIcon is implemented by menu items which can show an icon next to their title
+type MenuItemIcon interface {
+	GetIcon() *image.RGBA
+}
+
 type Menu interface {
 	AddNewMenuItem(menuItem MenuItem) int32
 	RemoveMenuItem(menuItem MenuItem)
@@ -0,0 +1,62 @@
+package systray
+
+import (
+	"sync"
+)
+
+// Logger receives the log output of the package, see SetLogger
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// loggerProxy forwards to the logger set with SetLogger, so it can be changed while the tray is running
+type loggerProxy struct {
+	logger Logger
+	lock   sync.RWMutex
+}
+
+func (p *loggerProxy) Debugf(format string, args ...interface{}) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	p.logger.Debugf(format, args...)
+}
+
+func (p *loggerProxy) Errorf(format string, args ...interface{}) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	p.logger.Errorf(format, args...)
+}
+
+// enabled reports whether a logger has been set with SetLogger
+func (p *loggerProxy) enabled() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	_, nop := p.logger.(nopLogger)
+	return !nop
+}
+
+var log = &loggerProxy{logger: nopLogger{}}
+
+// SetLogger will set where the package logs to. By default nothing is logged, apart from errors without an error handler
+// which are written to stderr. Passing nil restores the default
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	log.logger = logger
+}
+
+// SetTrace will enable logging every call into the platform's tray API at debug level, with its arguments, result and duration.
+// It is meant for diagnosing problems with a specific tray host and is slow, so it should not be left enabled
+func SetTrace(enabled bool) {
+	setNativeTrace(enabled)
+}
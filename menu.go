@@ -1,41 +1,74 @@
 package systray
 
 import (
-	"sync/atomic"
-
 	"github.com/reefbarman/systray/interfaces"
 )
 
 // Menu represents the top level or sub menus of a tray application
 type Menu struct {
-	handle uintptr
-	items  []interfaces.MenuItem
+	tray     *Tray
+	handle   uintptr
+	items    []interfaces.MenuItem
+	onOpened func()
+	onClosed func()
+}
+
+// OnMenuOpened will set a callback run on the loop thread when the tray menu is opened
+func (t *Tray) OnMenuOpened(f func()) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.rootMenuOpened = f
 }
 
-// AddSeparator will add a seperator to the menu
+// OnMenuClosed will set a callback run on the loop thread when the tray menu is closed
+func (t *Tray) OnMenuClosed(f func()) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.rootMenuClosed = f
+}
+
+// AddSeparator will add a seperator to the menu.
+// Errors are passed to the error handler of the tray, use Tray.AddSeparatorTo to receive them
 func (m *Menu) AddSeparator() {
-	id := atomic.AddInt32(&currentID, 1)
-	menuItem := &MenuItem{
-		id:     id,
-		parent: m,
+	if err := m.tray.AddSeparatorTo(m); err != nil {
+		m.tray.reportError(err)
 	}
-
-	addSeperator(menuItem)
 }
 
-// AddMenuItem will add an item to the menu
+// AddMenuItem will add an item to the menu.
+// Nil is returned if the item could not be added and the error is passed to the error handler of the tray, use Tray.AddMenuItemTo to receive it
 func (m *Menu) AddMenuItem(title string, onClick func(*MenuItem)) *MenuItem {
-	menuItem := createMenuItem(title, m)
-	menuItem.onClick = onClick
+	menuItem, err := m.tray.AddMenuItemTo(m, title, onClick)
+	if err != nil {
+		m.tray.reportError(err)
+	}
 
-	setMenuItem(menuItem)
 	return menuItem
 }
 
-// AddSubMenuItem will add a sub menu to the menu
+// AddSubMenuItem will add a sub menu to the menu.
+// Nil is returned if the sub menu could not be added and the error is passed to the error handler of the tray, use Tray.AddSubMenuItemTo to receive it
 func (m *Menu) AddSubMenuItem(title string) *Menu {
-	menuItem := createMenuItem(title, m)
-	return addSubMenuItem(menuItem)
+	subMenu, err := m.tray.AddSubMenuItemTo(m, title)
+	if err != nil {
+		m.tray.reportError(err)
+	}
+
+	return subMenu
+}
+
+// OnMenuOpened will set a callback run on the loop thread when this sub menu is opened
+func (m *Menu) OnMenuOpened(f func()) {
+	m.tray.callbacksLock.Lock()
+	defer m.tray.callbacksLock.Unlock()
+	m.onOpened = f
+}
+
+// OnMenuClosed will set a callback run on the loop thread when this sub menu is closed
+func (m *Menu) OnMenuClosed(f func()) {
+	m.tray.callbacksLock.Lock()
+	defer m.tray.callbacksLock.Unlock()
+	m.onClosed = f
 }
 
 // GetHandle will return the platform specific pointer to the raw menu resource
@@ -0,0 +1,165 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Menus are changed from many goroutines at once while the loop is delivering clicks, run with -race
+func TestConcurrentMenuMutation(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	const workers = 8
+	const itemsPerWorker = 20
+
+	var wg sync.WaitGroup
+	items := make(chan *MenuItem, workers*itemsPerWorker)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			sub, err := tray.AddSubMenuItem(fmt.Sprintf("Worker %d", w))
+			if err != nil {
+				t.Errorf("AddSubMenuItem: %v", err)
+				return
+			}
+
+			for i := 0; i < itemsPerWorker; i++ {
+				parent := sub
+				if i%2 == 0 {
+					parent = tray.menu
+				}
+
+				item, err := tray.AddMenuItemTo(parent, fmt.Sprintf("Item %d.%d", w, i), func(item *MenuItem) {
+					item.GetTitle()
+				})
+				if err != nil {
+					t.Errorf("AddMenuItemTo: %v", err)
+					return
+				}
+				if i%5 == 0 {
+					if err := tray.AddSeparatorTo(sub); err != nil {
+						t.Errorf("AddSeparatorTo: %v", err)
+					}
+				}
+
+				items <- item
+			}
+		}(w)
+	}
+
+	var mutators sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		mutators.Add(1)
+		go func(w int) {
+			defer mutators.Done()
+
+			for item := range items {
+				if err := tray.SetItemTitle(item, item.GetTitle()+" changed"); err != nil {
+					t.Errorf("SetItemTitle: %v", err)
+				}
+				if err := tray.ToggleItemChecked(item); err != nil {
+					t.Errorf("ToggleItemChecked: %v", err)
+				}
+				if item.GetID()%3 == 0 {
+					if err := tray.ToggleItemDisabled(item); err != nil {
+						t.Errorf("ToggleItemDisabled: %v", err)
+					}
+				}
+				if item.GetID()%7 == 0 {
+					if err := tray.SetItemDefault(item, true); err != nil {
+						t.Errorf("SetItemDefault: %v", err)
+					}
+				}
+
+				id := item.GetID()
+				tray.native.post(func() {
+					tray.onMenuItemSelected(id)
+				})
+				item.IsChecked()
+				item.IsDisabled()
+				item.IsDefault()
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(items)
+	mutators.Wait()
+
+	// Everything queued has run once the loop answers again
+	if err := tray.runOnLoop(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	tray.menuItemsLock.RLock()
+	defer tray.menuItemsLock.RUnlock()
+
+	defaults := 0
+	for id, item := range tray.menuItems {
+		native, ok := tray.native.item(id)
+		if !ok {
+			t.Errorf("item %d was never shown", id)
+			continue
+		}
+		if native.title != item.GetTitle() || native.checked != item.IsChecked() || native.disabled != item.IsDisabled() || native.isDefault != item.IsDefault() {
+			t.Errorf("item %d shows %+v, but is %q checked=%v disabled=%v default=%v",
+				id, native, item.GetTitle(), item.IsChecked(), item.IsDisabled(), item.IsDefault())
+		}
+		if item.IsDefault() {
+			defaults++
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("%d default items, want 1", defaults)
+	}
+}
+
+func TestFailedItemUpdatesRollBack(t *testing.T) {
+	reported := make(chan error, 3)
+	tray, stop := startTestTray(t, Options{
+		ErrorHandler: func(err error) {
+			reported <- err
+		},
+	})
+	defer stop()
+
+	item, err := tray.AddMenuItem("Item", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tray.native.setFailItems(true)
+
+	if err := tray.ToggleItemChecked(item); err == nil {
+		t.Error("ToggleItemChecked succeeded while the platform is failing")
+	}
+	if err := tray.ToggleItemDisabled(item); err == nil {
+		t.Error("ToggleItemDisabled succeeded while the platform is failing")
+	}
+	if err := tray.SetItemTitle(item, "Changed"); err == nil {
+		t.Error("SetItemTitle succeeded while the platform is failing")
+	}
+	if item.IsChecked() || item.IsDisabled() || item.GetTitle() != "Item" {
+		t.Errorf("item is %q checked=%v disabled=%v after failed updates, want it unchanged",
+			item.GetTitle(), item.IsChecked(), item.IsDisabled())
+	}
+
+	// The methods without an error return pass it to the error handler instead
+	item.ToogleChecked()
+	select {
+	case <-reported:
+	default:
+		t.Error("ToogleChecked did not pass its error to the error handler")
+	}
+	if item.IsChecked() {
+		t.Error("ToogleChecked left the item checked after failing")
+	}
+}
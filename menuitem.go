@@ -1,50 +1,228 @@
 package systray
 
+import (
+	"fmt"
+	"image"
+	"sync"
+)
+
 // MenuItem represents an item displayed in the root or a sub menu of the tray application
-// It can be disabled, checked or have the title updated
+// It can be disabled, checked or have the title updated. It is safe to use from any goroutine.
+// All of its methods have pointer receivers as it guards its state with a lock, so use the *MenuItem returned when
+// adding the item and do not copy the value
 type MenuItem struct {
-	id       int32
-	title    string
-	checked  bool
-	disabled bool
-	onClick  func(*MenuItem)
-	parent   *Menu
+	id        int32
+	title     string
+	checked   bool
+	disabled  bool
+	isDefault bool
+	icon      *image.RGBA
+	iconName  string
+	onClick   func(*MenuItem)
+	tray      *Tray
+	parent    *Menu
+	lock      sync.RWMutex
+
+	// Guarded by the handlerLock of the tray
+	handlerOptions   *HandlerOptions
+	handlersInFlight int
+	handlerPending   bool
 }
 
 // GetID will return the unique id of this menu item
-func (m MenuItem) GetID() int32 {
+func (m *MenuItem) GetID() int32 {
 	return m.id
 }
 
-// SetTitle allows the updating of the items title
+// SetTitle allows the updating of the items title.
+// Errors are passed to the error handler of the tray, use Tray.SetItemTitle to receive them
 func (m *MenuItem) SetTitle(title string) {
-	m.title = title
-	setMenuItem(m)
+	if err := m.tray.SetItemTitle(m, title); err != nil {
+		m.tray.reportError(err)
+	}
 }
 
 // GetTitle allows retrieving the current title
-func (m MenuItem) GetTitle() string {
+func (m *MenuItem) GetTitle() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.title
 }
 
-// ToogleChecked will switch the checked state on the item
+// ToogleChecked will switch the checked state on the item.
+// Errors are passed to the error handler of the tray, use Tray.ToggleItemChecked to receive them
 func (m *MenuItem) ToogleChecked() {
-	m.checked = !m.checked
-	setMenuItem(m)
+	if err := m.tray.ToggleItemChecked(m); err != nil {
+		m.tray.reportError(err)
+	}
 }
 
 // IsChecked allows checking the checked state of the item
-func (m MenuItem) IsChecked() bool {
+func (m *MenuItem) IsChecked() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.checked
 }
 
-// ToggleDisabled will switch the disabled state on the item
+// ToggleDisabled will switch the disabled state on the item.
+// Errors are passed to the error handler of the tray, use Tray.ToggleItemDisabled to receive them
 func (m *MenuItem) ToggleDisabled() {
-	m.disabled = !m.disabled
-	setMenuItem(m)
+	if err := m.tray.ToggleItemDisabled(m); err != nil {
+		m.tray.reportError(err)
+	}
 }
 
 // IsDisabled will allow the checking of the disabled state of the item
-func (m MenuItem) IsDisabled() bool {
+func (m *MenuItem) IsDisabled() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.disabled
 }
+
+// SetDefault will mark the item as the default action of the tray, replacing any previous default.
+// The default item is shown in bold and is triggered directly when the tray icon is double clicked.
+// Errors are passed to the error handler of the tray, use Tray.SetItemDefault to receive them
+func (m *MenuItem) SetDefault(isDefault bool) {
+	if err := m.tray.SetItemDefault(m, isDefault); err != nil {
+		m.tray.reportError(err)
+	}
+}
+
+// IsDefault will allow the checking of whether this item is the default action of the tray
+func (m *MenuItem) IsDefault() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.isDefault
+}
+
+func (m *MenuItem) setDefault(isDefault bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.isDefault = isDefault
+}
+
+// SetIconName will show the fallback for the named icon next to the title, an empty name and fallback remove the icon
+func (m *MenuItem) SetIconName(name string, fallback []byte) error {
+	var size int
+	if err := m.tray.runOnLoop(func() error {
+		size = m.tray.menuIconSize()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var img image.Image
+	if name != "" || len(fallback) > 0 {
+		var err error
+		if img, err = decodeNativeIcon(fallback, size); err != nil {
+			return fmt.Errorf("%w: icon %q: %v", ErrInvalidIcon, name, err)
+		}
+	}
+
+	return m.tray.runOnLoop(func() error {
+		var icon *image.RGBA
+		if img != nil {
+			// The size changes with the DPI, which may have happened meanwhile
+			icon = scaleImage(img, m.tray.menuIconSize())
+		}
+
+		m.lock.Lock()
+		m.icon = icon
+		m.iconName = name
+		m.lock.Unlock()
+
+		return m.tray.setMenuItem(m)
+	})
+}
+
+// GetIcon returns the icon shown next to the title scaled to the menu icon size, or nil
+func (m *MenuItem) GetIcon() *image.RGBA {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.icon
+}
+
+// GetIconName returns the name of the icon set with SetIconName, or an empty string
+func (m *MenuItem) GetIconName() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.iconName
+}
+
+// SetItemTitle will update the title of an item of the tray, returning the error MenuItem.SetTitle passes to the error handler
+func (t *Tray) SetItemTitle(item *MenuItem, title string) error {
+	if err := t.checkOwner(item.tray); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		item.lock.Lock()
+		previous := item.title
+		item.title = title
+		item.lock.Unlock()
+
+		if err := t.setMenuItem(item); err != nil {
+			item.lock.Lock()
+			item.title = previous
+			item.lock.Unlock()
+			return err
+		}
+
+		return nil
+	})
+}
+
+// SetItemDefault will mark an item of the tray as its default action, returning the error MenuItem.SetDefault passes to the error handler
+func (t *Tray) SetItemDefault(item *MenuItem, isDefault bool) error {
+	if err := t.checkOwner(item.tray); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		return t.setDefaultMenuItem(item, isDefault)
+	})
+}
+
+// ToggleItemChecked will switch the checked state of an item of the tray, returning the error MenuItem.ToogleChecked passes to the error handler
+func (t *Tray) ToggleItemChecked(item *MenuItem) error {
+	if err := t.checkOwner(item.tray); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		if err := t.toggleItem(item, &item.checked); err != nil {
+			return err
+		}
+
+		t.sendItemEvent(EventCheckedChanged, item)
+		return nil
+	})
+}
+
+// ToggleItemDisabled will switch the disabled state of an item of the tray, returning the error MenuItem.ToggleDisabled passes to the error handler
+func (t *Tray) ToggleItemDisabled(item *MenuItem) error {
+	if err := t.checkOwner(item.tray); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		return t.toggleItem(item, &item.disabled)
+	})
+}
+
+// toggleItem flips a state of the item and shows it, the state is switched back if the platform failed to show it.
+// It must be called on the loop thread
+func (t *Tray) toggleItem(item *MenuItem, state *bool) error {
+	item.lock.Lock()
+	*state = !*state
+	item.lock.Unlock()
+
+	if err := t.setMenuItem(item); err != nil {
+		item.lock.Lock()
+		*state = !*state
+		item.lock.Unlock()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoubleClickActivatesDefaultItem(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+
+	activated := make(chan int32, 4)
+	onClick := func(item *MenuItem) {
+		activated <- item.GetID()
+	}
+	first, err := tray.AddMenuItem("First", onClick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tray.AddMenuItem("Second", onClick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tray.native.detectsDoubleClicks() {
+		t.Error("double clicks are detected before anything handles them")
+	}
+
+	if err := tray.SetItemDefault(first, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tray.SetItemDefault(second, true); err != nil {
+		t.Fatal(err)
+	}
+	if first.IsDefault() || !second.IsDefault() {
+		t.Errorf("first default=%v second default=%v, want only the second item to be the default", first.IsDefault(), second.IsDefault())
+	}
+	if item, _ := tray.native.item(first.GetID()); item.isDefault {
+		t.Error("the previous default item is still shown as the default")
+	}
+	if !tray.native.detectsDoubleClicks() {
+		t.Error("double clicks are not detected while there is a default item")
+	}
+
+	tray.fakeClick(GestureDoubleClick, 1, 2)
+	select {
+	case id := <-activated:
+		if id != second.GetID() {
+			t.Errorf("item %d was activated, want the default item %d", id, second.GetID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("double clicking did not activate the default item")
+	}
+	if shown := tray.native.menusShown(); shown != 0 {
+		t.Errorf("the menu was shown %d times by a double click", shown)
+	}
+
+	// A disabled default item is not activated
+	if err := tray.ToggleItemDisabled(second); err != nil {
+		t.Fatal(err)
+	}
+	tray.fakeClick(GestureDoubleClick, 1, 2)
+
+	if err := tray.SetItemDefault(second, false); err != nil {
+		t.Fatal(err)
+	}
+	if tray.native.detectsDoubleClicks() {
+		t.Error("double clicks are still detected after the default item was cleared")
+	}
+	tray.fakeClick(GestureDoubleClick, 1, 2)
+
+	// Clicks are handled in order, so a primary click showing the menu means the double clicks before it were handled
+	tray.fakeClick(GesturePrimary, 1, 2)
+	deadline := time.Now().Add(time.Second)
+	for tray.native.menusShown() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case id := <-activated:
+		t.Errorf("item %d was activated while the default item was disabled or cleared", id)
+	default:
+	}
+}
+
+func TestSetDefaultReportsErrors(t *testing.T) {
+	reported := make(chan error, 1)
+	tray, stop := startTestTray(t, Options{
+		ErrorHandler: func(err error) {
+			reported <- err
+		},
+	})
+	defer stop()
+
+	item, err := tray.AddMenuItem("Item", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tray.native.setFailItems(true)
+	item.SetDefault(true)
+
+	select {
+	case <-reported:
+	default:
+		t.Error("SetDefault did not pass its error to the error handler")
+	}
+	if item.IsDefault() {
+		t.Error("SetDefault left the item marked as the default after failing")
+	}
+}
@@ -0,0 +1,80 @@
+package systray
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// scaleImage fits img into a size by size square, keeping its aspect ratio and centering it on a transparent background.
+// Every destination pixel is the average of the source area it covers, weighted by how much of each source pixel falls into it,
+// which keeps thin lines visible when an icon is scaled down a lot
+func scaleImage(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	// Work on premultiplied pixels so transparent pixels do not bleed their colour into the edges
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	if srcW == 0 || srcH == 0 || size == 0 {
+		return dst
+	}
+
+	scale := math.Max(float64(srcW), float64(srcH)) / float64(size)
+	dstW := int(math.Round(float64(srcW) / scale))
+	dstH := int(math.Round(float64(srcH) / scale))
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+	offX, offY := (size-dstW)/2, (size-dstH)/2
+	scaleX := float64(srcW) / float64(dstW)
+	scaleY := float64(srcH) / float64(dstH)
+
+	for dy := 0; dy < dstH; dy++ {
+		y0, y1 := float64(dy)*scaleY, float64(dy+1)*scaleY
+
+		for dx := 0; dx < dstW; dx++ {
+			x0, x1 := float64(dx)*scaleX, float64(dx+1)*scaleX
+
+			var r, g, b, a, total float64
+			for sy := int(y0); sy < srcH && float64(sy) < y1; sy++ {
+				wy := math.Min(y1, float64(sy+1)) - math.Max(y0, float64(sy))
+				if wy <= 0 {
+					continue
+				}
+
+				for sx := int(x0); sx < srcW && float64(sx) < x1; sx++ {
+					wx := math.Min(x1, float64(sx+1)) - math.Max(x0, float64(sx))
+					if wx <= 0 {
+						continue
+					}
+
+					w := wx * wy
+					i := src.PixOffset(sx, sy)
+					r += float64(src.Pix[i]) * w
+					g += float64(src.Pix[i+1]) * w
+					b += float64(src.Pix[i+2]) * w
+					a += float64(src.Pix[i+3]) * w
+					total += w
+				}
+			}
+
+			if total == 0 {
+				continue
+			}
+
+			i := dst.PixOffset(offX+dx, offY+dy)
+			dst.Pix[i] = uint8(math.Round(r / total))
+			dst.Pix[i+1] = uint8(math.Round(g / total))
+			dst.Pix[i+2] = uint8(math.Round(b / total))
+			dst.Pix[i+3] = uint8(math.Round(a / total))
+		}
+	}
+
+	return dst
+}
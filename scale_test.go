@@ -0,0 +1,69 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// filled returns a w by h image of a single colour
+func filled(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestScaleImage(t *testing.T) {
+	red := color.NRGBA{R: 0xff, A: 0xff}
+
+	scaled := scaleImage(filled(64, 64, red), 16)
+	if scaled.Bounds() != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("scaled to %v, want 16x16", scaled.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {8, 8}, {15, 15}} {
+		if c := color.NRGBAModel.Convert(scaled.At(p.X, p.Y)); c != red {
+			t.Errorf("%v is %v after scaling down, want %v", p, c, red)
+		}
+	}
+
+	// Scaling up keeps the colour as well
+	if c := color.NRGBAModel.Convert(scaleImage(filled(16, 16, red), 40).At(20, 20)); c != red {
+		t.Errorf("the centre is %v after scaling up, want %v", c, red)
+	}
+}
+
+func TestScaleImageKeepsAspectRatio(t *testing.T) {
+	wide := scaleImage(filled(32, 16, color.White), 16)
+
+	// The image fills the width and is centred vertically on a transparent background
+	for y := 0; y < 16; y++ {
+		_, _, _, a := wide.At(8, y).RGBA()
+		if inside := y >= 4 && y < 12; inside != (a != 0) {
+			t.Errorf("row %d has alpha %d", y, a>>8)
+		}
+	}
+}
+
+func TestScaleImageAveragesPixels(t *testing.T) {
+	// A checkerboard of black and white becomes grey rather than either of them
+	checker := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	checker.Set(0, 0, color.White)
+	checker.Set(1, 1, color.White)
+	checker.Set(1, 0, color.Black)
+	checker.Set(0, 1, color.Black)
+	if c := color.NRGBAModel.Convert(scaleImage(checker, 1).At(0, 0)).(color.NRGBA); c.R < 0x7e || c.R > 0x81 || c.A != 0xff {
+		t.Errorf("the checkerboard scaled to %v, want opaque grey", c)
+	}
+
+	// Transparent pixels do not bleed their colour into the result
+	edge := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	edge.Set(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+	edge.Set(1, 0, color.NRGBA{G: 0xff})
+	if c := color.NRGBAModel.Convert(scaleImage(edge, 1).At(0, 0)).(color.NRGBA); c.G != 0 || c.R != 0xff {
+		t.Errorf("half transparent red scaled to %v, want red without any green", c)
+	}
+}
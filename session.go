@@ -0,0 +1,53 @@
+package systray
+
+// SessionEvent is a change to the user's session or the machine's power state, see OnSessionEvent
+type SessionEvent int
+
+const (
+	// SessionLock is sent when the session is locked
+	SessionLock SessionEvent = iota
+	// SessionUnlock is sent when the session is unlocked
+	SessionUnlock
+	// SessionSuspend is sent when the machine is about to sleep or hibernate
+	SessionSuspend
+	// SessionResume is sent when the machine has woken up
+	SessionResume
+)
+
+func (e SessionEvent) String() string {
+	switch e {
+	case SessionLock:
+		return "lock"
+	case SessionUnlock:
+		return "unlock"
+	case SessionSuspend:
+		return "suspend"
+	case SessionResume:
+		return "resume"
+	default:
+		return "unknown"
+	}
+}
+
+// OnSessionEvent will set a callback run on the loop thread when the session is locked or unlocked and when the machine suspends or resumes.
+// A suspend callback should return quickly, the machine does not wait for it
+func (t *Tray) OnSessionEvent(f func(SessionEvent)) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.onSessionEvent = f
+}
+
+// onSessionChange is called on the loop thread by the platform when the session changes
+func (t *Tray) onSessionChange(event SessionEvent) {
+	t.callbacksLock.RLock()
+	callback := t.onSessionEvent
+	t.callbacksLock.RUnlock()
+
+	t.sendEvent(Event{Type: EventSession, Session: event})
+
+	if callback != nil {
+		t.safeCall(func() {
+			callback(event)
+		})
+	}
+}
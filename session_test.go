@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"testing"
+)
+
+func TestSessionEventsAreDelivered(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+	events := tray.Events()
+
+	received := make(chan SessionEvent, 4)
+	onLoop := make(chan bool, 4)
+	tray.OnSessionEvent(func(event SessionEvent) {
+		onLoop <- tray.isLoopThread()
+		received <- event
+	})
+
+	// The platform reports changes to the session on the loop thread, like the window procedure does
+	changes := []SessionEvent{SessionLock, SessionSuspend, SessionResume, SessionUnlock}
+	for _, change := range changes {
+		change := change
+		tray.native.post(func() {
+			tray.onSessionChange(change)
+		})
+	}
+
+	for _, want := range changes {
+		if got := <-received; got != want {
+			t.Errorf("callback got %v, want %v", got, want)
+		}
+		if !<-onLoop {
+			t.Error("session callback did not run on the loop thread")
+		}
+		if event := <-events; event.Type != EventSession || event.Session != want {
+			t.Errorf("got event %+v, want %v", event, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package systray
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// HandleSignals will close the tray application through the same path as Quit when one of the signals is received,
+// so the icon is removed and exit hooks run. The exit can not be vetoed and RunContext returns ErrSignal.
+// A signal received while the tray is starting closes it as soon as it has started. A signal received while no tray is running,
+// before Run or after the tray has exited, stops the handling and is delivered again with its default behaviour, which usually ends the process
+func (t *Tray) HandleSignals(sigs ...os.Signal) {
+	t.signalsLock.Lock()
+	defer t.signalsLock.Unlock()
+
+	if t.signals == nil {
+		t.signals = make(chan os.Signal, 1)
+		go t.watchSignals(t.signals)
+	}
+
+	signal.Notify(t.signals, sigs...)
+}
+
+// StopHandlingSignals will stop routing signals to the tray, restoring their default behaviour
+func (t *Tray) StopHandlingSignals() {
+	t.signalsLock.Lock()
+	defer t.signalsLock.Unlock()
+
+	if t.signals != nil {
+		signal.Stop(t.signals)
+	}
+}
+
+func (t *Tray) watchSignals(signals chan os.Signal) {
+	for sig := range signals {
+		switch {
+		case t.checkRunning() == nil:
+			go t.requestExit(ExitSignal, ErrSignal)
+		case !t.runInProgress():
+			t.StopHandlingSignals()
+			raiseDefault(sig)
+		case t.checkRunning() == ErrNotRunning:
+			atomic.StoreInt32(&t.pendingSignal, 1)
+		}
+		// Otherwise the tray is already shutting down
+	}
+}
+
+// handlePendingSignal is called once the tray is running to act on a signal received while it was starting
+func (t *Tray) handlePendingSignal() {
+	if atomic.SwapInt32(&t.pendingSignal, 0) == 1 {
+		go t.requestExit(ExitSignal, ErrSignal)
+	}
+}
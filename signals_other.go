@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"os"
+)
+
+// raiseDefault sends the signal to the process again, once nothing is notified of it the default behaviour applies
+func raiseDefault(sig os.Signal) {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+}
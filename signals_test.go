@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalClosesTray(t *testing.T) {
+	tray := New(Options{})
+	tray.HandleSignals(syscall.SIGUSR1)
+	defer tray.StopHandlingSignals()
+
+	reasons := make(chan ExitReason, 1)
+	tray.OnBeforeExit(func(reason ExitReason) bool {
+		reasons <- reason
+		return false
+	})
+
+	err := tray.RunContext(context.Background(), func() {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	})
+	if err != ErrSignal {
+		t.Errorf("RunContext returned %v, want ErrSignal", err)
+	}
+	if reason := <-reasons; reason != ExitSignal {
+		t.Errorf("hook got reason %v, want %v", reason, ExitSignal)
+	}
+}
+
+// signalHelper is set in the environment of the process started by TestSignalWithoutTray, telling it when to signal itself
+const signalHelper = "SYSTRAY_SIGNAL_HELPER"
+
+func TestSignalWithoutTray(t *testing.T) {
+	switch os.Getenv(signalHelper) {
+	case "":
+	case "before-run":
+		tray := New(Options{})
+		tray.HandleSignals(syscall.SIGTERM)
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	case "after-exit":
+		tray := New(Options{})
+		tray.HandleSignals(syscall.SIGTERM)
+		stop, err := tray.Start()
+		if err != nil {
+			os.Exit(3)
+		}
+		stop()
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	}
+
+	// No tray is running to close, so the signal ends the process as if it was not handled.
+	// SIGTERM is used as Go ignores SIGUSR1 when nothing is notified of it
+	for _, when := range []string{"before-run", "after-exit"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSignalWithoutTray$")
+		cmd.Env = append(os.Environ(), signalHelper+"="+when)
+		err := cmd.Run()
+
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Errorf("%s: the process survived the signal: %v", when, err)
+			continue
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+			t.Errorf("%s: the process ended with %v, want it to be killed by the signal", when, err)
+		}
+	}
+}
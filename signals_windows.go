@@ -0,0 +1,13 @@
+package systray
+
+import (
+	"os"
+)
+
+// STATUS_CONTROL_C_EXIT, the exit code of a console process ended by Ctrl+C
+const statusControlCExit = -1073741510
+
+// raiseDefault ends the process like the default console handler would, as Windows can not send the signal again
+func raiseDefault(sig os.Signal) {
+	os.Exit(statusControlCExit)
+}
@@ -1,134 +1,266 @@
 package systray
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"io/ioutil"
+	"context"
+	"image"
 	"os"
-	"path/filepath"
-	"runtime"
-	"sync"
-	"sync/atomic"
-
-	"github.com/getlantern/golog"
+	"time"
 )
 
 var (
-	// OnExitChan can be optionally waited on for detecting and handling the shutdown of the tray application
+	// OnExitChan can be optionally waited on for detecting and handling the shutdown of the tray application.
+	// The exit is only delivered if something is receiving at the moment the tray shuts down
+	//
+	// Deprecated: Use Done or OnExit, which can not miss the exit
 	OnExitChan = make(chan bool)
 
-	currentID = int32(-1)
-	menuItems = make(map[int32]*MenuItem)
-	log       = golog.LoggerFor("systray")
-
-	trayMenu      *Menu
-	menuItemsLock sync.RWMutex
-	onTrayRun     func()
+	defaultTray = newDefaultTray()
 )
 
+func newDefaultTray() *Tray {
+	t := New(Options{})
+	t.exitChan = OnExitChan
+	return t
+}
+
+// Default returns the tray used by the package level functions
+func Default() *Tray {
+	return defaultTray
+}
+
 // Run is called to start the tray application and the callback is triggered when it is up and running
 func Run(onRun func()) {
-	runtime.LockOSThread()
-
-	onTrayRun = func() {
-		menu, err := createMenu()
-		if err != nil {
-			log.Errorf("Unable to create root menu: %v", err)
-			return
-		}
-		trayMenu = menu
-
-		if onRun != nil {
-			go onRun()
-		}
-	}
+	defaultTray.Run(onRun)
+}
+
+// RunContext is called to start the tray application, the callback is triggered when it is up and running.
+// See Tray.RunContext for the errors returned
+func RunContext(ctx context.Context, onReady func()) error {
+	return defaultTray.RunContext(ctx, onReady)
+}
+
+// Start will run the tray application on its own goroutine and returns once it is running, see Tray.Start
+func Start() (stop func(), err error) {
+	return defaultTray.Start()
+}
 
-	nativeLoop()
+// Attach will start the tray application on a thread already running a message loop owned by the application, see Tray.Attach
+func Attach() error {
+	return defaultTray.Attach()
 }
 
-// Quit will close the tray application, the OnExitChan will be triggered after the application has shut down
+// Quit will start closing the tray application and returns without waiting for it.
+// Hooks registered with OnBeforeExit are run first and can veto the quit, Done will be closed after the application has shut down.
+// Errors are passed to the error handler, use Default().Quit to receive them and to find out whether the quit was vetoed
 func Quit() {
-	quit()
+	if err := defaultTray.Quit(); err != nil && err != ErrExitVetoed {
+		defaultTray.reportError(err)
+	}
 }
 
-// SetIcon will set the icon for the tray application in the system tray
+// SetIcon will set the icon for the tray application in the system tray.
+// Errors are passed to the error handler, use Default().SetIcon to receive them
 func SetIcon(iconBytes []byte) {
-	bh := md5.Sum(iconBytes)
-	dataHash := hex.EncodeToString(bh[:])
-	iconFilePath := filepath.Join(os.TempDir(), "systray_temp_icon_"+dataHash)
-
-	if _, err := os.Stat(iconFilePath); os.IsNotExist(err) {
-		if err := ioutil.WriteFile(iconFilePath, iconBytes, 0644); err != nil {
-			log.Errorf("Unable to write icon data to temp file: %v", err)
-			return
-		}
+	if err := defaultTray.SetIcon(iconBytes); err != nil {
+		defaultTray.reportError(err)
 	}
+}
+
+// SetIconImage will set the icon for the tray application from an image, converting it to the format the platform needs
+func SetIconImage(img image.Image) error {
+	return defaultTray.SetIconImage(img)
+}
+
+// SetIconImages will set the icon for the tray application from the same icon drawn at several sizes, see Tray.SetIconImages
+func SetIconImages(imgs ...image.Image) error {
+	return defaultTray.SetIconImages(imgs...)
+}
+
+// SetIconName will set the named icon for the tray application, see Tray.SetIconName
+func SetIconName(name string, fallback []byte) error {
+	return defaultTray.SetIconName(name, fallback)
+}
+
+// SetIconPNG will set the icon for the tray application from PNG data, converting it to the format the platform needs
+func SetIconPNG(pngBytes []byte) error {
+	return defaultTray.SetIconPNG(pngBytes)
+}
+
+// SetIconSet will set the icon for the tray application from the variant matching the current theme, see Tray.SetIconSet
+func SetIconSet(set IconSet) error {
+	return defaultTray.SetIconSet(set)
+}
+
+// CurrentTheme returns the theme of the panel the tray icon is shown on
+func CurrentTheme() Theme {
+	return defaultTray.Theme()
+}
+
+// OnThemeChanged will set a callback run on the loop thread when the theme of the panel changes
+func OnThemeChanged(f func(Theme)) {
+	defaultTray.OnThemeChanged(f)
+}
+
+// SetIconAnimation will cycle the tray icon through the frames, showing each one for the interval, see Tray.SetIconAnimation
+func SetIconAnimation(frames [][]byte, interval time.Duration) error {
+	return defaultTray.SetIconAnimation(frames, interval)
+}
+
+// StopIconAnimation will stop the animation started with SetIconAnimation and show the icon set with SetIcon again
+func StopIconAnimation() error {
+	return defaultTray.StopIconAnimation()
+}
+
+// SetBadge will draw a bubble with the text in the top right corner of the tray icon, see Tray.SetBadge
+func SetBadge(text string) error {
+	return defaultTray.SetBadge(text)
+}
+
+// SetOverlay will draw the image in a corner of the tray icon, see Tray.SetOverlay
+func SetOverlay(img image.Image, corner Corner) error {
+	return defaultTray.SetOverlay(img, corner)
+}
 
-	setIcon(iconFilePath)
+// SetVisible will show or hide the tray icon, a hidden icon keeps its menu and settings
+func SetVisible(visible bool) error {
+	return defaultTray.SetVisible(visible)
 }
 
-// SetTooltip will set a tooltip on hover over the system tray icon
+// SetTooltip will set a tooltip on hover over the system tray icon.
+// Errors are passed to the error handler, use Default().SetTooltip to receive them
 func SetTooltip(tooltip string) {
-	setTooltip(tooltip)
+	if err := defaultTray.SetTooltip(tooltip); err != nil {
+		defaultTray.reportError(err)
+	}
 }
 
-// AddSeparator will add a seperator between items in the tray menu
+// AddSeparator will add a seperator between items in the tray menu.
+// Errors are passed to the error handler, use Default().AddSeparator to receive them
 func AddSeparator() {
-	id := atomic.AddInt32(&currentID, 1)
-	menuItem := &MenuItem{
-		id:     id,
-		parent: trayMenu,
+	if err := defaultTray.AddSeparator(); err != nil {
+		defaultTray.reportError(err)
 	}
-
-	addSeperator(menuItem)
 }
 
-// AddMenuItem will add a new item to the tray menu with an on click callback
+// AddMenuItem will add a new item to the tray menu with an on click callback.
+// Nil is returned if the item could not be added and the error is passed to the error handler, use Default().AddMenuItem to receive it
 func AddMenuItem(title string, onClick func(*MenuItem)) *MenuItem {
-	menuItem := createMenuItem(title, trayMenu)
-	menuItem.onClick = onClick
-
-	setMenuItem(menuItem)
+	menuItem, err := defaultTray.AddMenuItem(title, onClick)
+	if err != nil {
+		defaultTray.reportError(err)
+	}
 
 	return menuItem
 }
 
-// AddSubMenuItem will add a new sub menu to the tray menu. The sub menu is returned, allowing the adding of items to it
+// AddSubMenuItem will add a new sub menu to the tray menu. The sub menu is returned, allowing the adding of items to it.
+// Nil is returned if the sub menu could not be added and the error is passed to the error handler, use Default().AddSubMenuItem to receive it
 func AddSubMenuItem(title string) *Menu {
-	menuItem := createMenuItem(title, trayMenu)
+	subMenu, err := defaultTray.AddSubMenuItem(title)
+	if err != nil {
+		defaultTray.reportError(err)
+	}
 
-	return addSubMenuItem(menuItem)
+	return subMenu
 }
 
-func createMenuItem(title string, parent *Menu) *MenuItem {
-	id := atomic.AddInt32(&currentID, 1)
+// OnMenuOpened will set a callback run on the loop thread when the tray menu is opened
+func OnMenuOpened(f func()) {
+	defaultTray.OnMenuOpened(f)
+}
 
-	menuItem := &MenuItem{
-		id:     id,
-		title:  title,
-		parent: parent,
-	}
+// OnMenuClosed will set a callback run on the loop thread when the tray menu is closed
+func OnMenuClosed(f func()) {
+	defaultTray.OnMenuClosed(f)
+}
+
+// Do will queue f to be run on the tray application's loop thread, see Tray.Do
+func Do(f func()) {
+	defaultTray.Do(f)
+}
 
-	menuItemsLock.Lock()
-	defer menuItemsLock.Unlock()
-	menuItems[id] = menuItem
+// SetErrorHandler will set a handler receiving errors that can not be returned to the caller,
+// such as panics in callbacks and failures of the platform tray. Passing nil restores the default, which logs the error
+// or writes it to stderr when no logger is set
+func SetErrorHandler(handler func(error)) {
+	defaultTray.SetErrorHandler(handler)
+}
 
-	return menuItem
+// Events returns a channel on which all tray events are delivered, see Tray.Events
+func Events() <-chan Event {
+	return defaultTray.Events()
 }
 
-func onMenuItemSelected(menuID int32) {
-	menuItemsLock.RLock()
-	item := menuItems[menuID]
-	menuItemsLock.RUnlock()
+// SetEventBuffer will set how many events the Events channel can buffer. It must be called before the first call to Events
+func SetEventBuffer(size int) {
+	defaultTray.SetEventBuffer(size)
+}
 
-	item.onClick(item)
+// DroppedEvents returns how many events were dropped because the Events channel was full
+func DroppedEvents() uint64 {
+	return defaultTray.DroppedEvents()
 }
 
-func onExit() {
-	select {
-	case OnExitChan <- true:
-		break
-	default:
-		break
-	}
+// SetHandlerOptions will set how on click handlers are run for all items that have not set their own options.
+// By default handlers are run inline on the loop thread
+func SetHandlerOptions(opts HandlerOptions) {
+	defaultTray.SetHandlerOptions(opts)
+}
+
+// OnActivate will set a callback run on the loop thread when the tray icon is left clicked, with the screen coordinates of the click
+func OnActivate(f func(x, y int)) {
+	defaultTray.OnActivate(f)
+}
+
+// OnSecondaryActivate will set a callback run on the loop thread when the tray icon is middle clicked, with the screen coordinates of the click
+func OnSecondaryActivate(f func(x, y int)) {
+	defaultTray.OnSecondaryActivate(f)
+}
+
+// OnDoubleClick will set a callback run on the loop thread when the tray icon is double clicked, with the screen coordinates of the click.
+// Once double clicks are handled left clicks are only reported after the double click time has passed
+func OnDoubleClick(f func(x, y int)) {
+	defaultTray.OnDoubleClick(f)
+}
+
+// SetMenuGestures will set which gestures open the tray menu, e.g. only GestureContext so a left click can be handled with OnActivate
+func SetMenuGestures(gestures Gesture) {
+	defaultTray.SetMenuGestures(gestures)
+}
+
+// OnSessionEvent will set a callback run on the loop thread when the session is locked or unlocked and when the machine suspends or resumes
+func OnSessionEvent(f func(SessionEvent)) {
+	defaultTray.OnSessionEvent(f)
+}
+
+// Done returns a channel that is closed once the tray application has shut down.
+// Any number of goroutines can wait on it, including after the tray has already exited
+func Done() <-chan struct{} {
+	return defaultTray.Done()
+}
+
+// OnExit will register a callback to be run on the loop thread once the tray application has shut down.
+// If the tray has already exited the callback is run immediately on the calling goroutine, as the loop thread is gone
+func OnExit(f func()) {
+	defaultTray.OnExit(f)
+}
+
+// OnBeforeExit will register a hook run before the tray application shuts down, see Tray.OnBeforeExit
+func OnBeforeExit(f func(reason ExitReason) bool) {
+	defaultTray.OnBeforeExit(f)
+}
+
+// SetExitTimeout will set how long the hooks registered with OnBeforeExit can run in total before the tray exits regardless
+func SetExitTimeout(timeout time.Duration) {
+	defaultTray.SetExitTimeout(timeout)
+}
+
+// HandleSignals will close the tray application through the same path as Quit when one of the signals is received, see Tray.HandleSignals
+func HandleSignals(sigs ...os.Signal) {
+	defaultTray.HandleSignals(sigs...)
+}
+
+// StopHandlingSignals will stop routing signals to the tray application, restoring their default behaviour
+func StopHandlingSignals() {
+	defaultTray.StopHandlingSignals()
 }
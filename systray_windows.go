@@ -1,84 +1,382 @@
+//go:build windows
 // +build windows
 
 package systray
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
 	"github.com/reefbarman/systray/win32"
 	"github.com/reefbarman/systray/wintray"
-	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
 
-var wt wintray.WinTray
+type nativeTray struct {
+	wt           wintray.WinTray
+	loopThreadID uint32
+	// iconSize is the size the shown icon was created at
+	iconSize int
+}
+
+func (t *Tray) initNative() {
+	wt := &t.native.wt
+
+	wt.OnTrayClick = func(click int, x, y int32) {
+		switch click {
+		case wintray.ClickPrimary:
+			t.onTrayClick(GesturePrimary, int(x), int(y))
+		case wintray.ClickSecondary:
+			t.onTrayClick(GestureSecondary, int(x), int(y))
+		case wintray.ClickContext:
+			t.onTrayClick(GestureContext, int(x), int(y))
+		case wintray.ClickDouble:
+			t.onTrayClick(GestureDoubleClick, int(x), int(y))
+		}
+	}
+
+	// The tray menu itself is reported by showTrayMenu, so only sub menus are handled here
+	wt.OnMenuOpened = func(handle uintptr) {
+		if menu, ok := t.subMenus[handle]; ok {
+			t.onMenuOpened(menu)
+		}
+	}
+	wt.OnMenuClosed = func(handle uintptr) {
+		if menu, ok := t.subMenus[handle]; ok {
+			t.onMenuClosed(menu)
+		}
+	}
+	wt.OnMenuItemSelected = t.onMenuItemSelected
+	wt.OnDispatch = t.drainQueue
+	wt.OnSessionEnd = t.onSessionEnd
+	wt.OnHostLost = t.onHostDisappeared
+	wt.OnSessionChange = func(change int) {
+		switch change {
+		case wintray.SessionLock:
+			t.onSessionChange(SessionLock)
+		case wintray.SessionUnlock:
+			t.onSessionChange(SessionUnlock)
+		case wintray.SessionSuspend:
+			t.onSessionChange(SessionSuspend)
+		case wintray.SessionResume:
+			t.onSessionChange(SessionResume)
+		}
+	}
+	wt.OnAnimationFrame = t.onAnimationFrame
+	wt.OnSettingChange = func() {
+		t.updateTheme()
+		t.updateIconSize()
+	}
+	wt.OnDisplayChange = t.updateIconSize
+	wt.OnExit = t.nativeExit
+}
+
+func nativeTheme() Theme {
+	switch {
+	case wintray.HighContrast():
+		return ThemeHighContrast
+	case wintray.SystemUsesLightTheme():
+		return ThemeLight
+	default:
+		return ThemeDark
+	}
+}
+
+func setNativeTrace(enabled bool) {
+	if !enabled {
+		win32.SetTracer(nil)
+		return
+	}
+
+	win32.SetTracer(func(name string, args []uintptr, r1 uintptr, err error, duration time.Duration) {
+		log.Debugf("systray: %s(%#x) = %#x, %v [%v]", name, args, r1, err, duration)
+	})
+}
+
+func (t *Tray) isLoopThread() bool {
+	id := atomic.LoadUint32(&t.native.loopThreadID)
+	return id != 0 && id == windows.GetCurrentThreadId()
+}
+
+func (t *Tray) wakeLoop() {
+	if err := t.native.wt.Dispatch(); err != nil {
+		t.reportError(fmt.Errorf("systray: unable to wake message loop: %w", err))
+	}
+}
+
+func (t *Tray) quit() {
+	t.native.wt.Quit()
+}
 
-func init() {
-	wt.OnTrayMenuOpened = func() {
-		wt.ShowTrayMenu(trayMenu)
+func (t *Tray) showTrayMenu() {
+	t.onMenuOpened(t.menu)
+	// The menu is modal, so this returns once it has been dismissed
+	if err := t.native.wt.ShowTrayMenu(t.menu); err != nil {
+		t.reportError(fmt.Errorf("systray: unable to show tray menu: %w", err))
 	}
+	t.onMenuClosed(t.menu)
+}
 
-	wt.OnMenuItemSelected = onMenuItemSelected
-	wt.OnExit = onExit
+func (t *Tray) setDoubleClickDetection(enabled bool) {
+	t.native.wt.SetDoubleClickDetection(enabled)
 }
 
-func quit() {
-	wt.Quit()
+func (t *Tray) setTooltip(tooltip string) error {
+	if err := t.native.wt.SetTooltip(tooltip); err != nil {
+		return fmt.Errorf("systray: unable to set tooltip: %w", err)
+	}
+
+	return nil
 }
 
-func setTooltip(tooltip string) {
-	if err := wt.SetTooltip(tooltip); err != nil {
-		log.Errorf("Unable to set tooltip: %v", err)
+func (t *Tray) addSeperator(menuItem *MenuItem) error {
+	if err := t.native.wt.AddSeparator(menuItem, menuItem.parent); err != nil {
+		return fmt.Errorf("systray: unable to add seperator: %w", err)
 	}
+
+	return nil
 }
 
-func addSeperator(menuItem *MenuItem) {
-	if err := wt.AddSeparator(menuItem, menuItem.parent); err != nil {
-		log.Errorf("Unable to add seperator: %v", err)
+func (t *Tray) setMenuItem(menuItem *MenuItem) error {
+	if err := t.native.wt.SetMenuItem(menuItem, menuItem.parent); err != nil {
+		return fmt.Errorf("systray: unable to set menu item: %w", err)
 	}
+
+	return nil
 }
 
-func setMenuItem(menuItem *MenuItem) {
-	if err := wt.SetMenuItem(menuItem, menuItem.parent); err != nil {
-		log.Errorf("Unable to add menu item: %v", err)
+func (t *Tray) setDefaultItem(menuItem *MenuItem) error {
+	if err := t.native.wt.SetDefaultMenuItem(menuItem, menuItem.parent); err != nil {
+		return fmt.Errorf("systray: unable to set default menu item: %w", err)
 	}
+
+	return nil
 }
 
-func addSubMenuItem(menuItem *MenuItem) *Menu {
-	subMenuHandle, err := wt.AddSubMenuItem(menuItem, menuItem.parent)
+func (t *Tray) clearDefaultItem(menuItem *MenuItem) error {
+	if err := t.native.wt.ClearDefaultMenuItem(menuItem, menuItem.parent); err != nil {
+		return fmt.Errorf("systray: unable to clear default menu item: %w", err)
+	}
+
+	return nil
+}
+
+func (t *Tray) addSubMenuItem(menuItem *MenuItem) (*Menu, error) {
+	subMenuHandle, err := t.native.wt.AddSubMenuItem(menuItem, menuItem.parent)
 	if err != nil {
-		log.Errorf("Unable to add menu item: %v", err)
-		return nil
+		return nil, fmt.Errorf("systray: unable to add sub menu: %w", err)
 	}
 
-	return &Menu{handle: subMenuHandle}
+	return &Menu{tray: t, handle: subMenuHandle}, nil
 }
 
-func createMenu() (*Menu, error) {
-	menuHandle, err := wt.CreateMenu()
+func (t *Tray) createMenu() (*Menu, error) {
+	menuHandle, err := t.native.wt.CreateMenu()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Menu{handle: menuHandle}, nil
+	return &Menu{tray: t, handle: menuHandle}, nil
+}
+
+// encodeNativeIcon converts images of an icon to an .ico file, which is what SetIcon takes
+func encodeNativeIcon(imgs ...image.Image) ([]byte, error) {
+	return encodeICO(imgs, icoSizes)
+}
+
+// validateIcon checks the icon is an .ico file, which is the format icons are created from
+func validateIcon(iconBytes []byte) error {
+	if _, err := decodeICO(iconBytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+
+	return nil
 }
 
-func setIcon(iconFilePath string) {
-	if err := wt.SetIcon(iconFilePath); err != nil {
-		log.Errorf("Unable to set icon: %v", err)
+// decodeNativeIcon decodes the image of an .ico file closest to size pixels
+func decodeNativeIcon(iconBytes []byte, size int) (image.Image, error) {
+	entries, err := decodeICO(iconBytes)
+	if err != nil {
+		return nil, err
 	}
+
+	return decodeICOImage(pickICOEntry(entries, size))
 }
 
-func nativeLoop() {
-	if err := wt.InitInstance(); err != nil {
-		log.Errorf("Unable to init instance: %v", err)
+// menuIconSize is the size icons are shown at next to menu items, the small icon size like the tray icon
+func (t *Tray) menuIconSize() int {
+	return t.native.wt.SmallIconSize()
+}
+
+// iconEntry picks the image of an .ico file to show at the small icon size, with the key its icon is cached under.
+// An image of another size is scaled here rather than by Windows, which only picks the nearest pixels.
+// Icons are cached by content, so switching back and forth between icons creates and scales each one once
+func (t *Tray) iconEntry(iconBytes []byte) (string, []byte, int, error) {
+	entries, err := decodeICO(iconBytes)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+
+	size := t.native.wt.SmallIconSize()
+	entry := pickICOEntry(entries, size)
+
+	sum := sha256.Sum256(entry.Data)
+	key := hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(size)
+
+	data := entry.Data
+	if (entry.Width != size || entry.Height != size) && !t.native.wt.IconLoaded(key) {
+		if data, err = resampleICOEntry(entry, size); err != nil {
+			return "", nil, 0, fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+		}
+	}
+
+	return key, data, size, nil
+}
+
+func (t *Tray) loadIcon(iconBytes []byte) error {
+	key, data, size, err := t.iconEntry(iconBytes)
+	if err != nil {
+		return err
+	}
+
+	if _, err := t.native.wt.LoadIcon(key, data, size); err != nil {
+		return fmt.Errorf("systray: unable to load icon: %w", err)
+	}
+
+	return nil
+}
+
+// reserveIconCache keeps the frames of an animation created on top of the icons usually kept, so they are not created again for every frame
+func (t *Tray) reserveIconCache(frames int) {
+	if frames == 0 {
+		t.native.wt.SetIconCacheSize(0)
+		return
+	}
+
+	t.native.wt.SetIconCacheSize(wintray.DefaultIconCacheSize + frames)
+}
+
+func (t *Tray) setIcon(iconBytes []byte) error {
+	key, data, size, err := t.iconEntry(iconBytes)
+	if err != nil {
+		return err
+	}
+
+	if err := t.native.wt.SetIcon(key, data, size); err != nil {
+		return fmt.Errorf("systray: unable to set icon: %w", err)
+	}
+	t.native.iconSize = size
+
+	return nil
+}
+
+func (t *Tray) clearIcon() error {
+	if err := t.native.wt.ClearIcon(); err != nil {
+		return fmt.Errorf("systray: unable to clear icon: %w", err)
+	}
+	t.native.iconSize = 0
+
+	return nil
+}
+
+// updateIconSize is called on the loop thread when the DPI or display changes, showing the icon again if the small icon size changed
+func (t *Tray) updateIconSize() {
+	if t.native.iconSize == 0 || t.native.wt.SmallIconSize() == t.native.iconSize {
 		return
 	}
 
+	if err := t.showCurrentIcon(); err != nil {
+		t.reportError(err)
+	}
+}
+
+func (t *Tray) setVisible(visible bool) error {
+	if err := t.native.wt.SetVisible(visible); err != nil {
+		return fmt.Errorf("systray: unable to change icon visibility: %w", err)
+	}
+
+	return nil
+}
+
+func (t *Tray) startAnimationTimer(interval time.Duration) error {
+	// Timers fire at most every 10ms, USER_TIMER_MINIMUM
+	ms := interval.Milliseconds()
+	if ms < 10 {
+		ms = 10
+	}
+
+	if err := t.native.wt.StartAnimation(uint32(ms)); err != nil {
+		return fmt.Errorf("systray: unable to start icon animation: %w", err)
+	}
+
+	return nil
+}
+
+func (t *Tray) stopAnimationTimer() {
+	t.native.wt.StopAnimation()
+}
+
+// nativeAttach creates the tray on the calling thread, leaving its messages to the message loop the application runs there
+func (t *Tray) nativeAttach() error {
+	wt := &t.native.wt
+	wt.Attached = true
+
+	if err := wt.InitInstance(); err != nil {
+		return fmt.Errorf("systray: unable to init instance: %w", err)
+	}
+
+	atomic.StoreUint32(&t.native.loopThreadID, windows.GetCurrentThreadId())
+
+	t.onTrayRun()
+	t.startDispatching()
+
+	return nil
+}
+
+// nativeExit is called on the loop thread while the window is destroyed, or once it has been destroyed when the tray is attached
+func (t *Tray) nativeExit() {
+	t.onTrayExit()
+
+	// Nothing returns to tear the tray down when the application owns the loop, so do it now
+	if t.native.wt.Attached {
+		t.stopDispatching()
+		atomic.StoreUint32(&t.native.loopThreadID, 0)
+		t.native.iconSize = 0
+		t.native.wt.DeInit()
+		t.finishExit()
+
+		// Locked by Attach
+		runtime.UnlockOSThread()
+	}
+}
+
+func (t *Tray) nativeLoop() error {
+	wt := &t.native.wt
+	wt.Attached = false
+
+	if err := wt.InitInstance(); err != nil {
+		return fmt.Errorf("systray: unable to init instance: %w", err)
+	}
+
+	atomic.StoreUint32(&t.native.loopThreadID, windows.GetCurrentThreadId())
+
 	defer func() {
+		t.stopDispatching()
+		atomic.StoreUint32(&t.native.loopThreadID, 0)
+		t.native.iconSize = 0
 		wt.DeInit()
 	}()
 
-	onTrayRun()
+	t.onTrayRun()
+	t.startDispatching()
 
 	// Main message pump.
 	m := &struct {
@@ -98,10 +396,9 @@ func nativeLoop() {
 		// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644936(v=vs.85).aspx
 		switch int32(ret) {
 		case -1:
-			log.Errorf("Error at message loop: %v", err)
-			return
+			return fmt.Errorf("systray: error at message loop: %w", err)
 		case 0:
-			return
+			return nil
 		default:
 			win32.TranslateMessage.Call(uintptr(unsafe.Pointer(m)))
 			win32.DispatchMessage.Call(uintptr(unsafe.Pointer(m)))
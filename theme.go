@@ -0,0 +1,130 @@
+package systray
+
+import (
+	"fmt"
+)
+
+// Theme is the appearance of the panel the tray icon is shown on
+type Theme int
+
+const (
+	// ThemeLight is a light panel, which needs a dark icon
+	ThemeLight Theme = iota
+	// ThemeDark is a dark panel, which needs a light icon
+	ThemeDark
+	// ThemeHighContrast is used while a high contrast theme is active
+	ThemeHighContrast
+)
+
+func (th Theme) String() string {
+	switch th {
+	case ThemeLight:
+		return "light"
+	case ThemeDark:
+		return "dark"
+	case ThemeHighContrast:
+		return "high contrast"
+	default:
+		return "unknown"
+	}
+}
+
+// IconSet holds variants of the tray icon for each theme, in the format SetIcon takes.
+// A missing variant falls back to another one: HighContrast to Dark and then Light, Dark and Light to each other
+type IconSet struct {
+	// Light is shown on light panels
+	Light []byte
+	// Dark is shown on dark panels
+	Dark []byte
+	// HighContrast is shown while a high contrast theme is active
+	HighContrast []byte
+}
+
+func (set *IconSet) forTheme(theme Theme) []byte {
+	var order [][]byte
+	switch theme {
+	case ThemeHighContrast:
+		order = [][]byte{set.HighContrast, set.Dark, set.Light}
+	case ThemeDark:
+		order = [][]byte{set.Dark, set.Light}
+	default:
+		order = [][]byte{set.Light, set.Dark}
+	}
+
+	for _, icon := range order {
+		if len(icon) > 0 {
+			return icon
+		}
+	}
+
+	return nil
+}
+
+// SetIconSet will set the icon for the tray from the variant matching the current theme, switching variants when the theme changes.
+// Setting an icon with SetIcon replaces the set
+func (t *Tray) SetIconSet(set IconSet) error {
+	if len(set.Light) == 0 && len(set.Dark) == 0 && len(set.HighContrast) == 0 {
+		return fmt.Errorf("%w: icon set is empty", ErrInvalidIcon)
+	}
+	for _, icon := range [][]byte{set.Light, set.Dark, set.HighContrast} {
+		if len(icon) == 0 {
+			continue
+		}
+		if err := validateIcon(icon); err != nil {
+			return err
+		}
+	}
+
+	return t.runOnLoop(func() error {
+		t.iconSet = &set
+		t.iconName = ""
+		return t.useIcon(set.forTheme(t.theme))
+	})
+}
+
+// Theme returns the theme of the panel the tray icon is shown on, it is ThemeLight until the tray is running
+func (t *Tray) Theme() Theme {
+	var theme Theme
+	if err := t.runOnLoop(func() error {
+		theme = t.theme
+		return nil
+	}); err != nil {
+		return ThemeLight
+	}
+
+	return theme
+}
+
+// OnThemeChanged will set a callback run on the loop thread when the theme of the panel changes
+func (t *Tray) OnThemeChanged(f func(Theme)) {
+	t.callbacksLock.Lock()
+	defer t.callbacksLock.Unlock()
+	t.onThemeChanged = f
+}
+
+// updateTheme is called on the loop thread when the tray starts and whenever the platform reports a change that can affect the theme
+func (t *Tray) updateTheme() {
+	theme := nativeTheme()
+	if theme == t.theme {
+		return
+	}
+	t.theme = theme
+
+	if t.iconSet != nil {
+		if err := t.useIcon(t.iconSet.forTheme(theme)); err != nil {
+			t.reportError(err)
+		}
+	}
+
+	t.callbacksLock.RLock()
+	callback := t.onThemeChanged
+	t.callbacksLock.RUnlock()
+
+	t.sendEvent(Event{Type: EventThemeChanged, Theme: theme})
+
+	if callback != nil {
+		t.safeCall(func() {
+			callback(theme)
+		})
+	}
+}
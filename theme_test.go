@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestIconSetFollowsTheme(t *testing.T) {
+	// The desktop is dark when the tray starts
+	setFakeTheme(ThemeDark)
+	defer setFakeTheme(ThemeLight)
+
+	tray, stop := startTestTray(t, Options{})
+	defer stop()
+	events := tray.Events()
+
+	themes := make(chan Theme, 4)
+	tray.OnThemeChanged(func(theme Theme) {
+		themes <- theme
+	})
+
+	if theme := tray.Theme(); theme != ThemeDark {
+		t.Errorf("tray started with the %v theme, want %v", theme, ThemeDark)
+	}
+
+	set := IconSet{
+		Light: testIcon(t, color.Black),
+		Dark:  testIcon(t, color.White),
+	}
+	if err := tray.SetIconSet(set); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tray.native.shownIcon(), set.Dark) {
+		t.Error("dark variant is not shown on the dark theme")
+	}
+
+	// switchTo changes the theme of the desktop and waits for the tray to report it
+	switchTo := func(theme Theme) {
+		t.Helper()
+
+		setFakeTheme(theme)
+		tray.fakeSettingChange()
+		if got := <-themes; got != theme {
+			t.Fatalf("theme changed to %v, want %v", got, theme)
+		}
+		if event := <-events; event.Type != EventThemeChanged || event.Theme != theme {
+			t.Errorf("got event %+v, want the change to %v", event, theme)
+		}
+	}
+
+	switchTo(ThemeLight)
+	if !bytes.Equal(tray.native.shownIcon(), set.Light) {
+		t.Error("light variant is not shown after switching to the light theme")
+	}
+
+	// Without a high contrast variant the dark one is used
+	switchTo(ThemeHighContrast)
+	if !bytes.Equal(tray.native.shownIcon(), set.Dark) {
+		t.Error("dark variant is not shown for high contrast")
+	}
+
+	// An icon set with SetIcon replaces the set, so later changes keep it
+	icon := testIcon(t, color.RGBA{R: 0xff, A: 0xff})
+	if err := tray.SetIcon(icon); err != nil {
+		t.Fatal(err)
+	}
+	switchTo(ThemeLight)
+	if !bytes.Equal(tray.native.shownIcon(), icon) {
+		t.Error("a theme change replaced the icon set with SetIcon")
+	}
+}
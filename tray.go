@@ -0,0 +1,579 @@
+package systray
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	stateNotRunning int32 = iota
+	stateRunning
+	stateClosed
+)
+
+// Options configures a tray created with New
+type Options struct {
+	// EventBuffer is the number of events buffered by the Events channel, DefaultEventBuffer is used if it is not set
+	EventBuffer int
+	// Handlers configures how the on click handlers of menu items are run, by default they are run inline on the loop thread
+	Handlers HandlerOptions
+	// ExitTimeout is how long hooks registered with OnBeforeExit can run, DefaultExitTimeout is used if it is not set
+	ExitTimeout time.Duration
+	// ErrorHandler receives errors that can not be returned to the caller, see SetErrorHandler
+	ErrorHandler func(error)
+}
+
+// Tray is a single icon in the system tray with its own tooltip, menu and callbacks.
+// A process can show several trays at once, each one is started with its own Run.
+// The package level functions operate on a default tray
+type Tray struct {
+	// Accessed atomically, kept first for alignment on 32 bit platforms
+	droppedEvents uint64
+
+	currentID     int32
+	state         int32
+	menu          *Menu
+	subMenus      map[uintptr]*Menu
+	defaultItem   *MenuItem
+	menuItems     map[int32]*MenuItem
+	menuItemsLock sync.RWMutex
+	onTrayRun     func()
+
+	// Owned by the loop thread
+	icon          []byte
+	animation     *iconAnimation
+	hidden        bool
+	badge         string
+	overlay       image.Image
+	overlayCorner Corner
+	decorated     map[[sha256.Size]byte][]byte
+	iconFrames    int
+	iconSet       *IconSet
+	iconName      string
+	theme         Theme
+
+	dispatchQueue     []func()
+	dispatchQueueLock sync.Mutex
+	dispatchState     int
+
+	events      chan Event
+	eventBuffer int
+	eventsLock  sync.Mutex
+
+	errorHandler     func(error)
+	errorHandlerLock sync.RWMutex
+
+	handlerOptions  HandlerOptions
+	handlerLock     sync.Mutex
+	serialQueue     chan func()
+	serialDone      chan struct{}
+	concurrentSlots chan struct{}
+
+	onActivate          func(x, y int)
+	onSecondaryActivate func(x, y int)
+	onDoubleClick       func(x, y int)
+	onSessionEvent      func(SessionEvent)
+	onThemeChanged      func(Theme)
+	menuGestures        Gesture
+	rootMenuOpened      func()
+	rootMenuClosed      func()
+	callbacksLock       sync.RWMutex
+
+	exitReason      ExitReason
+	exitErr         error
+	exitSet         bool
+	done            chan struct{}
+	exited          bool
+	running         bool
+	exitCallbacks   []func()
+	exitChan        chan bool
+	exitLock        sync.Mutex
+	beforeExitHooks []func(ExitReason) bool
+	exitTimeout     time.Duration
+	hooksLock       sync.RWMutex
+	exitRequest     chan struct{}
+
+	signals       chan os.Signal
+	signalsLock   sync.Mutex
+	pendingSignal int32
+
+	native nativeTray
+}
+
+// New will create a tray, which is shown once it is started with Run or RunContext
+func New(opts Options) *Tray {
+	t := &Tray{
+		currentID:      -1,
+		subMenus:       make(map[uintptr]*Menu),
+		menuItems:      make(map[int32]*MenuItem),
+		eventBuffer:    DefaultEventBuffer,
+		errorHandler:   opts.ErrorHandler,
+		handlerOptions: opts.Handlers,
+		menuGestures:   DefaultMenuGestures,
+		done:           make(chan struct{}),
+		exitTimeout:    DefaultExitTimeout,
+		exitRequest:    make(chan struct{}, 1),
+	}
+
+	if opts.EventBuffer > 0 {
+		t.eventBuffer = opts.EventBuffer
+	}
+	if opts.ExitTimeout > 0 {
+		t.exitTimeout = opts.ExitTimeout
+	}
+
+	t.initNative()
+
+	return t
+}
+
+// Run is called to start the tray and the callback is triggered when it is up and running.
+// It blocks until the tray has exited
+func (t *Tray) Run(onRun func()) {
+	switch err := t.RunContext(context.Background(), onRun); err {
+	case ErrQuit, ErrSignal, ErrSessionEnd:
+	default:
+		t.reportError(fmt.Errorf("systray: tray application exited: %w", err))
+	}
+}
+
+// RunContext is called to start the tray, the callback is triggered when it is up and running.
+// Once Done is closed the tray can be run again, starting with an empty menu while callbacks and options are kept,
+// until then ErrAlreadyRunning is returned.
+// The tray is shut down when the context is cancelled and the reason the tray exited is returned:
+// ErrQuit after Quit, ErrSessionEnd when the user logs out or the system shuts down, ErrSignal after a signal passed to HandleSignals,
+// ErrHostDisappeared when the icon could not be shown again after the tray host restarted,
+// the context's error when it is cancelled or an error from the platform when the tray failed to start or the message loop failed.
+// Hooks registered with OnBeforeExit run before the tray shuts down for any of these reasons except a failure
+func (t *Tray) RunContext(ctx context.Context, onReady func()) error {
+	if err := t.beginRun(); err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	t.reset()
+	t.onTrayRun = t.startCallback(ctx, onReady)
+
+	if err := t.nativeLoop(); err != nil {
+		t.setExit(ExitError, err)
+	}
+	t.finishExit()
+
+	_, err := t.currentExit()
+	return err
+}
+
+// Start will run the tray on its own goroutine, locked to a thread of its own, and returns once the tray is running.
+// The returned function closes the tray and waits for it to exit, hooks registered with OnBeforeExit can not veto it.
+// When called on the loop thread, e.g. from an on click handler, it returns without waiting
+func (t *Tray) Start() (stop func(), err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	result := make(chan error, 1)
+
+	go func() {
+		result <- t.RunContext(ctx, func() {
+			close(ready)
+		})
+	}()
+
+	select {
+	case <-ready:
+	case err := <-result:
+		cancel()
+		return nil, err
+	}
+
+	var once sync.Once
+	stop = func() {
+		cancel()
+		if t.isLoopThread() {
+			return
+		}
+
+		once.Do(func() {
+			<-result
+		})
+	}
+
+	return stop, nil
+}
+
+// Attach will start the tray on the calling goroutine, whose message loop is run by the application, and returns once it is running
+func (t *Tray) Attach() error {
+	if err := t.beginRun(); err != nil {
+		return err
+	}
+
+	// Unlocked by the platform once the tray has been torn down
+	runtime.LockOSThread()
+
+	t.reset()
+	t.onTrayRun = t.startCallback(context.Background(), nil)
+
+	if err := t.nativeAttach(); err != nil {
+		t.setExit(ExitError, err)
+		t.finishExit()
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	if atomic.LoadInt32(&t.state) != stateRunning {
+		_, err := t.currentExit()
+		return err
+	}
+
+	return nil
+}
+
+// startCallback returns the function run on the loop thread once the platform tray has been created
+func (t *Tray) startCallback(ctx context.Context, onReady func()) func() {
+	return func() {
+		menu, err := t.createMenu()
+		if err != nil {
+			t.closeTray(ExitError, fmt.Errorf("systray: unable to create root menu: %w", err))
+			return
+		}
+		t.menu = menu
+		t.theme = nativeTheme()
+		t.updateDoubleClickDetection()
+		atomic.StoreInt32(&t.state, stateRunning)
+
+		t.handlePendingSignal()
+
+		if ctx.Done() != nil {
+			done := t.Done()
+			go func() {
+				select {
+				case <-ctx.Done():
+					t.requestExit(ExitCancelled, ctx.Err())
+				case <-done:
+				}
+			}()
+		}
+
+		if onReady != nil {
+			go t.safeCall(onReady)
+		}
+	}
+}
+
+// Quit will run the hooks registered with OnBeforeExit and start closing the tray, returning without waiting for it to shut down.
+// ErrExitVetoed is returned when a hook vetoed the quit, otherwise Done will be closed after the tray has shut down
+func (t *Tray) Quit() error {
+	if err := t.checkRunning(); err != nil {
+		return err
+	}
+
+	if !t.requestExit(ExitUserQuit, ErrQuit) {
+		return ErrExitVetoed
+	}
+
+	return nil
+}
+
+// reset clears the menu and state left behind by a previous run
+func (t *Tray) reset() {
+	atomic.StoreInt32(&t.state, stateNotRunning)
+	atomic.StoreInt32(&t.currentID, -1)
+
+	t.menu = nil
+	t.subMenus = make(map[uintptr]*Menu)
+	t.defaultItem = nil
+	t.icon = nil
+	t.animation = nil
+	t.reserveIcons(0)
+	t.hidden = false
+	t.badge = ""
+	t.overlay = nil
+	t.decorated = nil
+	t.iconSet = nil
+	t.iconName = ""
+
+	t.menuItemsLock.Lock()
+	t.menuItems = make(map[int32]*MenuItem)
+	t.menuItemsLock.Unlock()
+
+	t.resetDispatch()
+	t.resetExit()
+}
+
+// checkRunning returns the error for calling into the tray when it is not running
+func (t *Tray) checkRunning() error {
+	switch atomic.LoadInt32(&t.state) {
+	case stateRunning:
+		return nil
+	case stateClosed:
+		return ErrClosed
+	default:
+		return ErrNotRunning
+	}
+}
+
+// SetIcon will set the icon for the tray in the system tray.
+// The icon is loaded in memory, no files are written for it. While an animation is running the icon is shown once it stops
+func (t *Tray) SetIcon(iconBytes []byte) error {
+	if err := validateIcon(iconBytes); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		t.iconSet = nil
+		t.iconName = ""
+		return t.useIcon(iconBytes)
+	})
+}
+
+// useIcon makes the icon the static icon of the tray, it is shown unless an animation is running.
+// It must be called on the loop thread
+func (t *Tray) useIcon(iconBytes []byte) error {
+	t.icon = iconBytes
+	if t.animation != nil {
+		return nil
+	}
+
+	return t.showIcon(iconBytes)
+}
+
+// SetTooltip will set a tooltip on hover over the system tray icon
+func (t *Tray) SetTooltip(tooltip string) error {
+	return t.runOnLoop(func() error {
+		return t.setTooltip(tooltip)
+	})
+}
+
+// AddSeparator will add a seperator between items in the tray menu
+func (t *Tray) AddSeparator() error {
+	return t.runOnLoop(func() error {
+		return t.addSeparatorTo(t.menu)
+	})
+}
+
+// AddMenuItem will add a new item to the tray menu with an on click callback
+func (t *Tray) AddMenuItem(title string, onClick func(*MenuItem)) (*MenuItem, error) {
+	var menuItem *MenuItem
+	err := t.runOnLoop(func() (err error) {
+		menuItem, err = t.addMenuItemTo(t.menu, title, onClick)
+		return err
+	})
+
+	return menuItem, err
+}
+
+// AddSubMenuItem will add a new sub menu to the tray menu. The sub menu is returned, allowing the adding of items to it
+func (t *Tray) AddSubMenuItem(title string) (*Menu, error) {
+	var subMenu *Menu
+	err := t.runOnLoop(func() (err error) {
+		subMenu, err = t.addSubMenuItemTo(t.menu, title)
+		return err
+	})
+
+	return subMenu, err
+}
+
+// AddSeparatorTo will add a seperator to a menu of the tray, returning the error Menu.AddSeparator passes to the error handler
+func (t *Tray) AddSeparatorTo(menu *Menu) error {
+	if err := t.checkOwner(menu.tray); err != nil {
+		return err
+	}
+
+	return t.runOnLoop(func() error {
+		return t.addSeparatorTo(menu)
+	})
+}
+
+// AddMenuItemTo will add an item to a menu of the tray, returning the error Menu.AddMenuItem passes to the error handler
+func (t *Tray) AddMenuItemTo(menu *Menu, title string, onClick func(*MenuItem)) (*MenuItem, error) {
+	if err := t.checkOwner(menu.tray); err != nil {
+		return nil, err
+	}
+
+	var menuItem *MenuItem
+	err := t.runOnLoop(func() (err error) {
+		menuItem, err = t.addMenuItemTo(menu, title, onClick)
+		return err
+	})
+
+	return menuItem, err
+}
+
+// AddSubMenuItemTo will add a sub menu to a menu of the tray, returning the error Menu.AddSubMenuItem passes to the error handler
+func (t *Tray) AddSubMenuItemTo(menu *Menu, title string) (*Menu, error) {
+	if err := t.checkOwner(menu.tray); err != nil {
+		return nil, err
+	}
+
+	var subMenu *Menu
+	err := t.runOnLoop(func() (err error) {
+		subMenu, err = t.addSubMenuItemTo(menu, title)
+		return err
+	})
+
+	return subMenu, err
+}
+
+// checkOwner returns an error for menus and items belonging to another tray
+func (t *Tray) checkOwner(owner *Tray) error {
+	if owner != t {
+		return errors.New("systray: menu belongs to another tray")
+	}
+
+	return nil
+}
+
+func (t *Tray) addSeparatorTo(parent *Menu) error {
+	id := atomic.AddInt32(&t.currentID, 1)
+	menuItem := &MenuItem{
+		id:     id,
+		tray:   t,
+		parent: parent,
+	}
+
+	return t.addSeperator(menuItem)
+}
+
+func (t *Tray) addMenuItemTo(parent *Menu, title string, onClick func(*MenuItem)) (*MenuItem, error) {
+	menuItem := t.createMenuItem(title, parent, onClick)
+	if err := t.setMenuItem(menuItem); err != nil {
+		t.removeMenuItem(menuItem)
+		return nil, err
+	}
+
+	return menuItem, nil
+}
+
+func (t *Tray) addSubMenuItemTo(parent *Menu, title string) (*Menu, error) {
+	menuItem := t.createMenuItem(title, parent, nil)
+
+	subMenu, err := t.addSubMenuItem(menuItem)
+	if err != nil {
+		t.removeMenuItem(menuItem)
+		return nil, err
+	}
+	t.subMenus[subMenu.handle] = subMenu
+
+	return subMenu, nil
+}
+
+func (t *Tray) createMenuItem(title string, parent *Menu, onClick func(*MenuItem)) *MenuItem {
+	id := atomic.AddInt32(&t.currentID, 1)
+
+	menuItem := &MenuItem{
+		id:      id,
+		title:   title,
+		onClick: onClick,
+		tray:    t,
+		parent:  parent,
+	}
+
+	t.menuItemsLock.Lock()
+	defer t.menuItemsLock.Unlock()
+	t.menuItems[id] = menuItem
+
+	return menuItem
+}
+
+func (t *Tray) removeMenuItem(menuItem *MenuItem) {
+	t.menuItemsLock.Lock()
+	defer t.menuItemsLock.Unlock()
+	delete(t.menuItems, menuItem.id)
+}
+
+func (t *Tray) onMenuItemSelected(menuID int32) {
+	t.menuItemsLock.RLock()
+	item, ok := t.menuItems[menuID]
+	t.menuItemsLock.RUnlock()
+
+	if !ok {
+		t.reportError(fmt.Errorf("systray: selected menu item %d does not exist", menuID))
+		return
+	}
+
+	t.sendItemEvent(EventItemClicked, item)
+	t.runHandler(item)
+}
+
+// setDefaultMenuItem must be called on the loop thread, which owns defaultItem
+func (t *Tray) setDefaultMenuItem(menuItem *MenuItem, isDefault bool) error {
+	defer t.updateDoubleClickDetection()
+
+	if !isDefault {
+		if t.defaultItem != menuItem {
+			return nil
+		}
+
+		t.defaultItem = nil
+		menuItem.setDefault(false)
+		return t.clearDefaultItem(menuItem)
+	}
+
+	if previous := t.defaultItem; previous != nil && previous != menuItem {
+		t.defaultItem = nil
+		previous.setDefault(false)
+		if err := t.clearDefaultItem(previous); err != nil {
+			return err
+		}
+	}
+
+	t.defaultItem = menuItem
+	menuItem.setDefault(true)
+	if err := t.setDefaultItem(menuItem); err != nil {
+		t.defaultItem = nil
+		menuItem.setDefault(false)
+		return err
+	}
+
+	return nil
+}
+
+// activateDefaultItem is called on the loop thread when the tray icon is double clicked
+func (t *Tray) activateDefaultItem() {
+	item := t.defaultItem
+	if item == nil || item.IsDisabled() {
+		return
+	}
+
+	t.onMenuItemSelected(item.id)
+}
+
+// onMenuOpened is called on the loop thread when the tray menu or one of its sub menus is opened
+func (t *Tray) onMenuOpened(menu *Menu) {
+	t.callbacksLock.RLock()
+	callback := menu.onOpened
+	if menu == t.menu {
+		callback = t.rootMenuOpened
+	}
+	t.callbacksLock.RUnlock()
+
+	t.sendEvent(Event{Type: EventMenuOpened, Menu: menu})
+
+	if callback != nil {
+		t.safeCall(callback)
+	}
+}
+
+// onMenuClosed is called on the loop thread when the tray menu or one of its sub menus is closed
+func (t *Tray) onMenuClosed(menu *Menu) {
+	t.callbacksLock.RLock()
+	callback := menu.onClosed
+	if menu == t.menu {
+		callback = t.rootMenuClosed
+	}
+	t.callbacksLock.RUnlock()
+
+	t.sendEvent(Event{Type: EventMenuClosed, Menu: menu})
+
+	if callback != nil {
+		t.safeCall(callback)
+	}
+}
@@ -0,0 +1,397 @@
+//go:build !windows
+// +build !windows
+
+package systray
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// startTestTray runs a tray on the fake backend, the returned function closes it and waits for it to exit
+func startTestTray(tb testing.TB, opts Options) (*Tray, func()) {
+	tb.Helper()
+
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = func(err error) {
+			tb.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	tray := New(opts)
+	stop, err := tray.Start()
+	if err != nil {
+		tb.Fatalf("Start: %v", err)
+	}
+
+	return tray, stop
+}
+
+// waitFor fails the test unless the channel is closed within a second
+func waitFor(tb testing.TB, ch <-chan struct{}, what string) {
+	tb.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		tb.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// testIcon returns icon data in the format of the platform, filled with a single colour
+func testIcon(tb testing.TB, c color.Color) []byte {
+	tb.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	iconBytes, err := encodeNativeIcon(img)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return iconBytes
+}
+
+func TestRestartLeavesNothingBehind(t *testing.T) {
+	tray := New(Options{
+		ErrorHandler: func(err error) {
+			t.Errorf("unexpected error: %v", err)
+		},
+	})
+	tray.SetHandlerOptions(HandlerOptions{Mode: HandlerSerial})
+	goroutines := runtime.NumGoroutine()
+
+	for run := 0; run < 3; run++ {
+		stop, err := tray.Start()
+		if err != nil {
+			t.Fatalf("run %d: Start: %v", run, err)
+		}
+
+		if err := tray.RunContext(context.Background(), nil); err != ErrAlreadyRunning {
+			t.Errorf("run %d: RunContext while running returned %v, want ErrAlreadyRunning", run, err)
+		}
+		if _, err := tray.Start(); err != ErrAlreadyRunning {
+			t.Errorf("run %d: Start while running returned %v, want ErrAlreadyRunning", run, err)
+		}
+
+		tray.menuItemsLock.RLock()
+		left := len(tray.menuItems)
+		tray.menuItemsLock.RUnlock()
+		if left != 0 {
+			t.Errorf("run %d: started with %d items of the previous run", run, left)
+		}
+
+		clicked := make(chan struct{})
+		item, err := tray.AddMenuItem("Item", func(*MenuItem) {
+			close(clicked)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tray.AddSubMenuItem("Sub Menu"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tray.SetIcon(testIcon(t, color.White)); err != nil {
+			t.Fatal(err)
+		}
+		tray.native.post(func() {
+			tray.onMenuItemSelected(item.GetID())
+		})
+		waitFor(t, clicked, "the click to be handled")
+
+		// Everything the platform handed out is released by the time Done is closed
+		done := tray.Done()
+		released := make(chan [2]int, 1)
+		go func() {
+			<-done
+			menus, icons := tray.native.live()
+			released <- [2]int{menus, icons}
+		}()
+
+		stop()
+		if live := <-released; live != [2]int{} {
+			t.Errorf("run %d: %d menus and %d icons were alive when Done was closed", run, live[0], live[1])
+		}
+	}
+
+	// The loop, the handler worker and the watchers of every run have exited
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines are running after the last run, %d were before the first", n, goroutines)
+	}
+}
+
+func TestFailedAttachFinishesRun(t *testing.T) {
+	tray := New(Options{})
+	tray.native.setFailInit(true)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := tray.Attach()
+		if err == nil || err == ErrAlreadyRunning {
+			t.Fatalf("attempt %d: Attach returned %v, want the error of the platform", attempt, err)
+		}
+		waitFor(t, tray.Done(), "Done to be closed after the failed attach")
+	}
+}
+
+func TestStartAndStop(t *testing.T) {
+	tray := New(Options{
+		ErrorHandler: func(err error) {
+			t.Errorf("unexpected error: %v", err)
+		},
+	})
+
+	for run := 0; run < 2; run++ {
+		stop, err := tray.Start()
+		if err != nil {
+			t.Fatalf("run %d: Start: %v", run, err)
+		}
+		select {
+		case <-tray.Done():
+			t.Fatalf("run %d: Done was closed while the tray is running", run)
+		default:
+		}
+
+		stop()
+		select {
+		case <-tray.Done():
+		default:
+			t.Fatalf("run %d: stop returned before Done was closed", run)
+		}
+		if reason, _ := tray.currentExit(); reason != ExitCancelled {
+			t.Errorf("run %d: exited with %v, want %v", run, reason, ExitCancelled)
+		}
+
+		// Stopping again has nothing left to do
+		stop()
+	}
+}
+
+func TestStopOnLoopThread(t *testing.T) {
+	tray, stop := startTestTray(t, Options{})
+	done := tray.Done()
+
+	// Waiting on the loop thread would never return, as the loop has to run for the tray to exit
+	stopped := make(chan struct{})
+	tray.native.post(func() {
+		stop()
+		close(stopped)
+	})
+	waitFor(t, stopped, "stop to return on the loop thread")
+	waitFor(t, done, "the tray to exit")
+}
+
+func TestStartReturnsRunError(t *testing.T) {
+	tray := New(Options{})
+	tray.native.setFailInit(true)
+
+	stop, err := tray.Start()
+	if err == nil {
+		stop()
+		t.Fatal("Start succeeded although the window could not be created")
+	}
+	if stop != nil {
+		t.Error("Start returned a stop function with its error")
+	}
+	waitFor(t, tray.Done(), "Done to be closed after the failed run")
+
+	// The failed run has finished, so the tray can be started again
+	tray.native.setFailInit(false)
+	stop, err = tray.Start()
+	if err != nil {
+		t.Fatalf("Start after the failed run: %v", err)
+	}
+	stop()
+}
+
+func TestAttachLifecycle(t *testing.T) {
+	tray := New(Options{
+		ErrorHandler: func(err error) {
+			t.Errorf("unexpected error: %v", err)
+		},
+	})
+
+	for run := 0; run < 2; run++ {
+		// The application owns the loop and runs it on the goroutine that attached the tray
+		attached := make(chan error, 1)
+		looped := make(chan struct{})
+		go func() {
+			defer close(looped)
+
+			err := tray.Attach()
+			attached <- err
+			if err != nil {
+				return
+			}
+			for tray.pumpMessage() {
+			}
+		}()
+
+		if err := <-attached; err != nil {
+			t.Fatalf("run %d: Attach: %v", run, err)
+		}
+		if err := tray.Attach(); err != ErrAlreadyRunning {
+			t.Errorf("run %d: Attach while attached returned %v, want ErrAlreadyRunning", run, err)
+		}
+
+		clicked := make(chan struct{})
+		item, err := tray.AddMenuItem("Item", func(*MenuItem) {
+			close(clicked)
+		})
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		tray.native.post(func() {
+			tray.onMenuItemSelected(item.GetID())
+		})
+		waitFor(t, clicked, "the click to be handled by the application's loop")
+
+		if err := tray.Quit(); err != nil {
+			t.Fatalf("run %d: Quit: %v", run, err)
+		}
+		waitFor(t, tray.Done(), "the attached tray to exit")
+		waitFor(t, looped, "the application's loop to see the tray torn down")
+
+		if menus, icons := tray.native.live(); menus != 0 || icons != 0 {
+			t.Errorf("run %d: %d menus and %d icons were alive after the tray exited", run, menus, icons)
+		}
+		if reason, _ := tray.currentExit(); reason != ExitUserQuit {
+			t.Errorf("run %d: exited with %v, want %v", run, reason, ExitUserQuit)
+		}
+	}
+}
+
+func TestCancelledContextEndsRun(t *testing.T) {
+	tray := New(Options{
+		ErrorHandler: func(err error) {
+			t.Errorf("unexpected error: %v", err)
+		},
+	})
+	events := tray.Events()
+
+	// Hooks see why the tray is exiting but can only veto a quit
+	reasons := make(chan ExitReason, 1)
+	tray.OnBeforeExit(func(reason ExitReason) bool {
+		reasons <- reason
+		return false
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- tray.RunContext(ctx, func() {
+			close(ready)
+		})
+	}()
+	waitFor(t, ready, "the tray to start")
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Errorf("RunContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RunContext did not return after the context was cancelled")
+	}
+
+	if reason := <-reasons; reason != ExitCancelled {
+		t.Errorf("hook got reason %v, want %v", reason, ExitCancelled)
+	}
+	for event := range events {
+		if event.Type == EventExit {
+			if event.Reason != ExitCancelled || event.Err != context.Canceled {
+				t.Errorf("exit event has %v, %v, want %v, %v", event.Reason, event.Err, ExitCancelled, context.Canceled)
+			}
+			break
+		}
+	}
+
+	// A context that is already done closes the tray as soon as it has started
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := tray.RunContext(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("RunContext with an expired context returned %v, want context.DeadlineExceeded", err)
+	}
+	if reason := <-reasons; reason != ExitCancelled {
+		t.Errorf("hook got reason %v, want %v", reason, ExitCancelled)
+	}
+}
+
+func TestTraysAreIndependent(t *testing.T) {
+	first, stopFirst := startTestTray(t, Options{})
+	defer stopFirst()
+	second, stopSecond := startTestTray(t, Options{})
+	defer stopSecond()
+
+	clicks := make(chan string, 2)
+	firstItem, err := first.AddMenuItem("First", func(*MenuItem) {
+		clicks <- "first"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondItem, err := second.AddMenuItem("Second", func(*MenuItem) {
+		clicks <- "second"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each tray runs its own loop and hands out its own ids
+	if first.isLoopThread() || second.isLoopThread() {
+		t.Error("the test goroutine is the loop thread of a tray")
+	}
+	onSecond := make(chan bool, 1)
+	second.Do(func() {
+		onSecond <- first.isLoopThread()
+	})
+	if <-onSecond {
+		t.Error("both trays run on the same loop")
+	}
+	if firstItem.GetID() != secondItem.GetID() {
+		t.Errorf("the first items have ids %d and %d, want each tray to start at the same id", firstItem.GetID(), secondItem.GetID())
+	}
+
+	if err := first.SetIcon(testIcon(t, color.Black)); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SetTooltip("Second"); err != nil {
+		t.Fatal(err)
+	}
+	if second.native.shownIcon() != nil || first.native.shownTooltip() != "" {
+		t.Error("changing one tray changed the other")
+	}
+
+	// The same id selects the item of the tray it was selected in
+	second.native.post(func() {
+		second.onMenuItemSelected(secondItem.GetID())
+	})
+	if clicked := <-clicks; clicked != "second" {
+		t.Errorf("selecting the item of the second tray ran the handler of the %s", clicked)
+	}
+
+	// Items can not be moved to another tray
+	if err := second.SetItemTitle(firstItem, "Moved"); err == nil {
+		t.Error("the second tray changed an item of the first")
+	}
+
+	// Closing one tray leaves the other running
+	stopFirst()
+	if err := first.SetTooltip("Closed"); err != ErrClosed {
+		t.Errorf("SetTooltip on the closed tray returned %v, want ErrClosed", err)
+	}
+	if err := second.SetTooltip("Still running"); err != nil {
+		t.Errorf("SetTooltip on the running tray returned %v", err)
+	}
+}
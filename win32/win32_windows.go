@@ -1,46 +1,114 @@
+//go:build windows
 // +build windows
+
 package win32
 
-import "golang.org/x/sys/windows"
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
 
 // Helpful sources: https://github.com/golang/exp/blob/master/shiny/driver/internal/win32
 
 var (
+	g32 = windows.NewLazySystemDLL("Gdi32.dll")
 	k32 = windows.NewLazySystemDLL("Kernel32.dll")
 	s32 = windows.NewLazySystemDLL("Shell32.dll")
 	u32 = windows.NewLazySystemDLL("User32.dll")
+	wts = windows.NewLazySystemDLL("Wtsapi32.dll")
 )
 
 var (
-	GetModuleHandle       = k32.NewProc("GetModuleHandleW")
-	ShellNotifyIcon       = s32.NewProc("Shell_NotifyIconW")
-	CreatePopupMenu       = u32.NewProc("CreatePopupMenu")
-	CreateWindowEx        = u32.NewProc("CreateWindowExW")
-	DefWindowProc         = u32.NewProc("DefWindowProcW")
-	DeleteMenu            = u32.NewProc("DeleteMenu")
-	DestroyWindow         = u32.NewProc("DestroyWindow")
-	DispatchMessage       = u32.NewProc("DispatchMessageW")
-	GetCursorPos          = u32.NewProc("GetCursorPos")
-	GetMenuItemID         = u32.NewProc("GetMenuItemID")
-	GetMessage            = u32.NewProc("GetMessageW")
-	InsertMenuItem        = u32.NewProc("InsertMenuItemW")
-	LoadIcon              = u32.NewProc("LoadIconW")
-	LoadImage             = u32.NewProc("LoadImageW")
-	LoadCursor            = u32.NewProc("LoadCursorW")
-	PostMessage           = u32.NewProc("PostMessageW")
-	PostQuitMessage       = u32.NewProc("PostQuitMessage")
-	RegisterClass         = u32.NewProc("RegisterClassExW")
-	RegisterWindowMessage = u32.NewProc("RegisterWindowMessageW")
-	SetForegroundWindow   = u32.NewProc("SetForegroundWindow")
-	SetMenuInfo           = u32.NewProc("SetMenuInfo")
-	SetMenuItemInfo       = u32.NewProc("SetMenuItemInfoW")
-	ShowWindow            = u32.NewProc("ShowWindow")
-	TrackPopupMenu        = u32.NewProc("TrackPopupMenu")
-	TranslateMessage      = u32.NewProc("TranslateMessage")
-	UnregisterClass       = u32.NewProc("UnregisterClassW")
-	UpdateWindow          = u32.NewProc("UpdateWindow")
+	GetModuleHandle       = newProc(k32, "GetModuleHandleW")
+	ShellNotifyIcon       = newProc(s32, "Shell_NotifyIconW")
+	CreatePopupMenu       = newProc(u32, "CreatePopupMenu")
+	CreateWindowEx        = newProc(u32, "CreateWindowExW")
+	DefWindowProc         = newProc(u32, "DefWindowProcW")
+	DeleteMenu            = newProc(u32, "DeleteMenu")
+	DestroyIcon           = newProc(u32, "DestroyIcon")
+	DestroyMenu           = newProc(u32, "DestroyMenu")
+	DestroyWindow         = newProc(u32, "DestroyWindow")
+	DispatchMessage       = newProc(u32, "DispatchMessageW")
+	GetCursorPos          = newProc(u32, "GetCursorPos")
+	GetDoubleClickTime    = newProc(u32, "GetDoubleClickTime")
+	GetMenuItemID         = newProc(u32, "GetMenuItemID")
+	GetMessage            = newProc(u32, "GetMessageW")
+	GetSystemMetrics      = newProc(u32, "GetSystemMetrics")
+	InsertMenuItem        = newProc(u32, "InsertMenuItemW")
+	KillTimer             = newProc(u32, "KillTimer")
+	LoadIcon              = newProc(u32, "LoadIconW")
+	LoadImage             = newProc(u32, "LoadImageW")
+	LoadCursor            = newProc(u32, "LoadCursorW")
+	PostMessage           = newProc(u32, "PostMessageW")
+	PostQuitMessage       = newProc(u32, "PostQuitMessage")
+	RegisterClass         = newProc(u32, "RegisterClassExW")
+	RegisterWindowMessage = newProc(u32, "RegisterWindowMessageW")
+	SetForegroundWindow   = newProc(u32, "SetForegroundWindow")
+	SetMenuDefaultItem    = newProc(u32, "SetMenuDefaultItem")
+	SetMenuInfo           = newProc(u32, "SetMenuInfo")
+	SetMenuItemInfo       = newProc(u32, "SetMenuItemInfoW")
+	SetTimer              = newProc(u32, "SetTimer")
+	ShowWindow            = newProc(u32, "ShowWindow")
+	SystemParametersInfo  = newProc(u32, "SystemParametersInfoW")
+	TrackPopupMenu        = newProc(u32, "TrackPopupMenu")
+	TranslateMessage      = newProc(u32, "TranslateMessage")
+	UnregisterClass       = newProc(u32, "UnregisterClassW")
+	UpdateWindow          = newProc(u32, "UpdateWindow")
+
+	CreateIconFromResourceEx = newProc(u32, "CreateIconFromResourceEx")
+
+	// Only available since Windows 10 1607, check with Available before calling
+	GetDpiForWindow              = newProc(u32, "GetDpiForWindow")
+	GetSystemMetricsForDpi       = newProc(u32, "GetSystemMetricsForDpi")
+	SetThreadDpiAwarenessContext = newProc(u32, "SetThreadDpiAwarenessContext")
+
+	CreateDIBSection = newProc(g32, "CreateDIBSection")
+	DeleteObject     = newProc(g32, "DeleteObject")
+
+	WTSRegisterSessionNotification   = newProc(wts, "WTSRegisterSessionNotification")
+	WTSUnRegisterSessionNotification = newProc(wts, "WTSUnRegisterSessionNotification")
 )
 
+// Tracer is called after every call to a Proc while it is set with SetTracer
+type Tracer func(name string, args []uintptr, r1 uintptr, err error, duration time.Duration)
+
+var tracer atomic.Value
+
+// SetTracer will set a function called after every call into the system DLLs, nil stops tracing
+func SetTracer(t Tracer) {
+	tracer.Store(t)
+}
+
+// Proc is a procedure in a system DLL, calls to it can be traced using SetTracer
+type Proc struct {
+	*windows.LazyProc
+}
+
+func newProc(dll *windows.LazyDLL, name string) *Proc {
+	return &Proc{dll.NewProc(name)}
+}
+
+// Call calls the procedure with the given arguments, reporting the call to the tracer if one is set
+func (p *Proc) Call(args ...uintptr) (r1, r2 uintptr, lastErr error) {
+	t, _ := tracer.Load().(Tracer)
+	if t == nil {
+		return p.LazyProc.Call(args...)
+	}
+
+	start := time.Now()
+	r1, r2, lastErr = p.LazyProc.Call(args...)
+	t(p.Name, args, r1, lastErr, time.Since(start))
+
+	return r1, r2, lastErr
+}
+
+// Available reports whether the procedure exists in the DLL, which is not the case for calls added in later versions of Windows
+func (p *Proc) Available() bool {
+	return p.Find() == nil
+}
+
 // https://msdn.microsoft.com/en-us/library/windows/desktop/dd162805(v=vs.85).aspx
 type Point struct {
 	X int32
@@ -75,16 +143,47 @@ const NIF_MESSAGE = 0x00000001
 const MIM_APPLYTOSUBMENUS = 0x80000000 // Settings apply to the menu and all of its submenus
 
 const (
-	WM_DESTROY    = 0x0002
-	WM_CLOSE      = 0x0010
-	WM_COMMAND    = 0x0111
-	WM_LBUTTONUP  = 0x0202
-	WM_RBUTTONUP  = 0x0205
-	WM_ENDSESSION = 0x16
+	WM_DESTROY           = 0x0002
+	WM_CLOSE             = 0x0010
+	WM_SETTINGCHANGE     = 0x001A
+	WM_DISPLAYCHANGE     = 0x007E
+	WM_COMMAND           = 0x0111
+	WM_TIMER             = 0x0113
+	WM_INITMENUPOPUP     = 0x0117
+	WM_UNINITMENUPOPUP   = 0x0125
+	WM_LBUTTONUP         = 0x0202
+	WM_LBUTTONDBLCLK     = 0x0203
+	WM_RBUTTONUP         = 0x0205
+	WM_MBUTTONUP         = 0x0208
+	WM_ENDSESSION        = 0x16
+	WM_POWERBROADCAST    = 0x0218
+	WM_WTSSESSION_CHANGE = 0x02B1
+	WM_DPICHANGED        = 0x02E0
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644931(v=vs.85).aspx
 	WM_USER = 0x0400
 )
 
+// https://docs.microsoft.com/en-us/windows/win32/power/wm-powerbroadcast
+const (
+	PBT_APMSUSPEND         = 0x0004
+	PBT_APMRESUMESUSPEND   = 0x0007
+	PBT_APMRESUMEAUTOMATIC = 0x0012
+)
+
+// https://docs.microsoft.com/en-us/windows/win32/termserv/wm-wtssession-change
+const (
+	WTS_SESSION_LOCK   = 0x7
+	WTS_SESSION_UNLOCK = 0x8
+)
+
+const NOTIFY_FOR_THIS_SESSION = 0
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-systemparametersinfow
+const SPI_GETHIGHCONTRAST = 0x0042
+const HCF_HIGHCONTRASTON = 0x00000001
+
+const NIS_HIDDEN = 0x00000001
+
 const (
 	NIM_ADD    = 0x00000000
 	NIM_MODIFY = 0x00000001
@@ -96,6 +195,22 @@ const (
 	TPM_LEFTALIGN   = 0x0000
 )
 
+// https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-createiconfromresourceex
+const ICON_RESOURCE_VERSION = 0x00030000
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-getsystemmetrics
+const SM_CXSMICON = 49
+
+// DPI_AWARENESS_CONTEXT_PER_MONITOR_AWARE_V2 is the handle (DPI_AWARENESS_CONTEXT)-4
+// https://docs.microsoft.com/en-us/windows/win32/hidpi/dpi-awareness-context
+const DPI_AWARENESS_CONTEXT_PER_MONITOR_AWARE_V2 = ^uintptr(3)
+
+// https://docs.microsoft.com/en-us/windows/win32/api/wingdi/nf-wingdi-createdibsection
+const (
+	BI_RGB         = 0
+	DIB_RGB_COLORS = 0
+)
+
 const IMAGE_ICON = 1 // Loads an icon
 const (
 	LR_LOADFROMFILE = 0x00000010 // Loads the stand-alone image from the file
@@ -103,8 +218,9 @@ const (
 )
 
 const (
-	NIF_ICON = 0x00000002
-	NIF_TIP  = 0x00000004
+	NIF_ICON  = 0x00000002
+	NIF_TIP   = 0x00000004
+	NIF_STATE = 0x00000008
 )
 
 // https://msdn.microsoft.com/en-us/library/windows/desktop/ms647578(v=vs.85).aspx
@@ -113,12 +229,14 @@ const (
 	MIIM_ID      = 0x00000002
 	MIIM_SUBMENU = 0x00000004
 	MIIM_STRING  = 0x00000040
+	MIIM_BITMAP  = 0x00000080
 	MIIM_FTYPE   = 0x00000100
 )
 
 const (
 	MFS_CHECKED  = 0x00000008
 	MFS_DISABLED = 0x00000003
+	MFS_DEFAULT  = 0x00001000
 )
 
 const (
@@ -0,0 +1,107 @@
+package wintray
+
+import (
+	"image"
+	"unsafe"
+
+	"github.com/reefbarman/systray/interfaces"
+	"github.com/reefbarman/systray/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// menuBitmap is the bitmap created for the icon of a menu item
+type menuBitmap struct {
+	icon   *image.RGBA
+	handle windows.Handle
+}
+
+// Returns the bitmap showing the icon of the menu item, or 0 when it has none.
+// The bitmap is created again only when the item gets another icon, the one it replaces is deleted
+func (t *WinTray) menuBitmap(menuItem interfaces.MenuItem) (windows.Handle, error) {
+	var icon *image.RGBA
+	if withIcon, ok := menuItem.(interfaces.MenuItemIcon); ok {
+		icon = withIcon.GetIcon()
+	}
+	current, ok := t.menuBitmaps[menuItem.GetID()]
+	if ok && current.icon == icon {
+		return current.handle, nil
+	}
+
+	var handle windows.Handle
+	if icon != nil {
+		var err error
+		if handle, err = createBitmap(icon); err != nil {
+			return 0, err
+		}
+	}
+
+	// Menus only draw the bitmap while open, which can not happen before the caller updates the item on this thread
+	if ok {
+		win32.DeleteObject.Call(uintptr(current.handle))
+	}
+	if icon == nil {
+		delete(t.menuBitmaps, menuItem.GetID())
+	} else {
+		t.menuBitmaps[menuItem.GetID()] = menuBitmap{icon: icon, handle: handle}
+	}
+
+	return handle, nil
+}
+
+// Creates a 32 bit top down bitmap with premultiplied alpha, which menus draw with transparency since Vista
+// https://docs.microsoft.com/en-us/windows/win32/api/wingdi/nf-wingdi-createdibsection
+func createBitmap(img *image.RGBA) (windows.Handle, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	header := struct {
+		Size          uint32
+		Width         int32
+		Height        int32
+		Planes        uint16
+		BitCount      uint16
+		Compression   uint32
+		SizeImage     uint32
+		XPelsPerMeter int32
+		YPelsPerMeter int32
+		ClrUsed       uint32
+		ClrImportant  uint32
+	}{
+		Width: int32(width),
+		// A negative height makes the first row the top one, like the image
+		Height:      -int32(height),
+		Planes:      1,
+		BitCount:    32,
+		Compression: win32.BI_RGB,
+	}
+	header.Size = uint32(unsafe.Sizeof(header))
+
+	var bits unsafe.Pointer
+	res, _, err := win32.CreateDIBSection.Call(
+		0,
+		uintptr(unsafe.Pointer(&header)),
+		win32.DIB_RGB_COLORS,
+		uintptr(unsafe.Pointer(&bits)),
+		0,
+		0,
+	)
+	if res == 0 {
+		return 0, err
+	}
+
+	// image.RGBA is already premultiplied, the bitmap only needs its channels in BGRA order
+	size := width * height * 4
+	dst := (*[1 << 30]byte)(bits)[:size:size]
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		for x := 0; x < width*4; x += 4 {
+			i := y*width*4 + x
+			dst[i+0] = row[x+2]
+			dst[i+1] = row[x+1]
+			dst[i+2] = row[x+0]
+			dst[i+3] = row[x+3]
+		}
+	}
+
+	return windows.Handle(res), nil
+}
@@ -0,0 +1,41 @@
+package wintray
+
+import (
+	"unsafe"
+
+	"github.com/reefbarman/systray/win32"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// Reports whether the taskbar uses the light theme. Before Windows 10 1903 the value does not exist and the taskbar is always dark
+// https://docs.microsoft.com/en-us/windows/apps/desktop/modernize/apply-windows-themes
+func SystemUsesLightTheme() bool {
+	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Themes\Personalize`, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer key.Close()
+
+	value, _, err := key.GetIntegerValue("SystemUsesLightTheme")
+	return err == nil && value != 0
+}
+
+// Reports whether a high contrast theme is active
+// https://docs.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-highcontrastw
+func HighContrast() bool {
+	hc := struct {
+		Size          uint32
+		Flags         uint32
+		DefaultScheme *uint16
+	}{}
+	hc.Size = uint32(unsafe.Sizeof(hc))
+
+	res, _, _ := win32.SystemParametersInfo.Call(
+		win32.SPI_GETHIGHCONTRAST,
+		uintptr(hc.Size),
+		uintptr(unsafe.Pointer(&hc)),
+		0,
+	)
+	return res != 0 && hc.Flags&win32.HCF_HIGHCONTRASTON != 0
+}
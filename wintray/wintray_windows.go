@@ -1,6 +1,9 @@
 package wintray
 
 import (
+	"container/list"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/reefbarman/systray/interfaces"
@@ -9,30 +12,97 @@ import (
 	"golang.org/x/sys/windows"
 )
 
+// Timers of the tray window
+const (
+	// Delays reporting a left click until we know it is not part of a double click
+	clickTimerID = 1
+	// Shows the next frame of an icon animation
+	animationTimerID = 2
+)
+
+// DefaultIconCacheSize is how many icons are kept created unless changed with SetIconCacheSize
+const DefaultIconCacheSize = 16
+
+// Clicks on the tray icon reported through OnTrayClick
+const (
+	ClickPrimary = iota
+	ClickSecondary
+	ClickContext
+	ClickDouble
+)
+
+// Changes to the session reported through OnSessionChange
+const (
+	SessionLock = iota
+	SessionUnlock
+	SessionSuspend
+	SessionResume
+)
+
+// The hidden window of every tray in the process uses the same class and window procedure
+const className = "SystrayClass"
+
+var (
+	windowClass     *wndClassEx
+	windowClassRefs int
+	windowClassLock sync.Mutex
+
+	// Lets the shared window procedure find the tray owning the window a message is for
+	trays     = make(map[windows.Handle]*WinTray)
+	traysLock sync.RWMutex
+
+	wndProcCallback = windows.NewCallback(wndProc)
+
+	// The shell identifies an icon by its window and id, give each tray its own id
+	lastIconID uint32 = 99
+)
+
 type WinTray struct {
-	OnTrayMenuOpened   func()
+	// Attached is set when the application runs the message loop of the thread instead of the tray.
+	// The tray then never posts WM_QUIT, which would stop the application's loop, and OnExit is called once the window
+	// has been destroyed instead of while it is being destroyed, so it can tear the tray down
+	Attached bool
+
+	OnTrayClick        func(click int, x, y int32)
+	OnMenuOpened       func(menu uintptr)
+	OnMenuClosed       func(menu uintptr)
 	OnMenuItemSelected func(menuId int32)
+	OnDispatch         func()
+	OnSessionEnd       func()
+	OnHostLost         func(err error)
+	OnSessionChange    func(change int)
+	OnAnimationFrame   func()
+	OnSettingChange    func()
+	OnDisplayChange    func()
 	OnExit             func()
 
 	instance         windows.Handle
 	icon             windows.Handle
 	cursor           windows.Handle
 	window           windows.Handle
-	loadedImages     map[string]windows.Handle
+	loadedImages     map[string]*list.Element
+	iconLRU          *list.List
+	iconCacheSize    int
+	menuBitmaps      map[int32]menuBitmap
 	nid              *notifyIconData
-	wcex             *wndClassEx
 	wmSystrayMessage uint32
+	wmDispatch       uint32
 	wmTaskbarCreated uint32
 	visibleItems     []uint32
+	menus            []windows.Handle
+	detectDblClick   bool
+	ignoreLButtonUp  bool
+	pendingClick     win32.Point
+	sessionNotify    bool
+	destroyed        bool
+	suspended        bool
 }
 
-func (t *WinTray) InitInstance() error {
-	const (
-		className  = "SystrayClass"
-		windowName = ""
-	)
+func (t *WinTray) InitInstance() (err error) {
+	const windowName = ""
 
 	t.wmSystrayMessage = win32.WM_USER + 1
+	t.wmDispatch = win32.WM_USER + 2
 
 	taskbarEventNamePtr, _ := windows.UTF16PtrFromString("TaskbarCreated")
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644947
@@ -41,7 +111,9 @@ func (t *WinTray) InitInstance() error {
 	)
 	t.wmTaskbarCreated = uint32(res)
 
-	t.loadedImages = make(map[string]windows.Handle)
+	t.loadedImages = make(map[string]*list.Element)
+	t.iconLRU = list.New()
+	t.menuBitmaps = make(map[int32]menuBitmap)
 
 	instanceHandle, _, err := win32.GetModuleHandle.Call(0)
 	if instanceHandle == 0 {
@@ -73,19 +145,24 @@ func (t *WinTray) InitInstance() error {
 		return err
 	}
 
-	t.wcex = &wndClassEx{
-		Style:      win32.CS_HREDRAW | win32.CS_VREDRAW,
-		WndProc:    windows.NewCallback(t.wndProc),
-		Instance:   t.instance,
-		Icon:       t.icon,
-		Cursor:     t.cursor,
-		Background: windows.Handle(6), // (COLOR_WINDOW + 1)
-		ClassName:  classNamePtr,
-		IconSm:     t.icon,
-	}
-	if err := t.wcex.register(); err != nil {
+	if err := t.acquireClass(classNamePtr); err != nil {
 		return err
 	}
+	// Everything created from here on is released again when a later step fails, e.g. adding the icon before the taskbar exists
+	defer func() {
+		if err != nil {
+			t.DeInit()
+		}
+	}()
+
+	// A window created per monitor aware gets the DPI of its monitor and WM_DPICHANGED even when the process is not DPI aware,
+	// so SmallIconSize is not scaled for a 96 DPI process. The thread's previous awareness is restored once the window exists
+	if win32.SetThreadDpiAwarenessContext.Available() {
+		previous, _, _ := win32.SetThreadDpiAwarenessContext.Call(win32.DPI_AWARENESS_CONTEXT_PER_MONITOR_AWARE_V2)
+		if previous != 0 {
+			defer win32.SetThreadDpiAwarenessContext.Call(previous)
+		}
+	}
 
 	windowHandle, _, err := win32.CreateWindowEx.Call(
 		uintptr(0),
@@ -106,6 +183,14 @@ func (t *WinTray) InitInstance() error {
 	}
 	t.window = windows.Handle(windowHandle)
 
+	traysLock.Lock()
+	trays[t.window] = t
+	traysLock.Unlock()
+
+	// Lock and unlock are only reported once registered, which fails when the terminal services are not running
+	res, _, _ = win32.WTSRegisterSessionNotification.Call(uintptr(t.window), win32.NOTIFY_FOR_THIS_SESSION)
+	t.sessionNotify = res != 0
+
 	win32.ShowWindow.Call(
 		uintptr(t.window),
 		uintptr(win32.SW_HIDE),
@@ -117,7 +202,7 @@ func (t *WinTray) InitInstance() error {
 
 	t.nid = &notifyIconData{
 		Wnd:             windows.Handle(t.window),
-		ID:              100,
+		ID:              atomic.AddUint32(&lastIconID, 1),
 		Flags:           win32.NIF_MESSAGE,
 		CallbackMessage: t.wmSystrayMessage,
 	}
@@ -126,15 +211,115 @@ func (t *WinTray) InitInstance() error {
 	return t.nid.add()
 }
 
+// Releases everything created since InitInstance, after which InitInstance can be called again
 func (t *WinTray) DeInit() {
+	win32.KillTimer.Call(uintptr(t.window), clickTimerID)
+	win32.KillTimer.Call(uintptr(t.window), animationTimerID)
+	if t.sessionNotify {
+		win32.WTSUnRegisterSessionNotification.Call(uintptr(t.window))
+	}
+
+	// Forgotten first, so destroying the window below does not report an exit or post WM_QUIT to the thread
+	traysLock.Lock()
+	delete(trays, t.window)
+	traysLock.Unlock()
+
+	// The window is already gone when the tray is torn down after it was destroyed,
+	// otherwise InitInstance or the message loop failed
+	if !t.destroyed && t.window != 0 {
+		if t.nid != nil {
+			t.nid.delete()
+		}
+		win32.DestroyWindow.Call(uintptr(t.window))
+	}
+
+	releaseClass()
+
+	for _, e := range t.loadedImages {
+		win32.DestroyIcon.Call(uintptr(e.Value.(*loadedIcon).handle))
+	}
+	for _, menu := range t.menus {
+		win32.DestroyMenu.Call(uintptr(menu))
+	}
+	// The menus are gone, so nothing uses the bitmaps of their items any more
+	for _, b := range t.menuBitmaps {
+		win32.DeleteObject.Call(uintptr(b.handle))
+	}
+
+	t.window = 0
+	t.nid = nil
+	t.loadedImages = nil
+	t.iconLRU = nil
+	t.menuBitmaps = nil
+	t.menus = nil
+	t.visibleItems = nil
+	t.ignoreLButtonUp = false
+	t.sessionNotify = false
+	t.suspended = false
+	t.destroyed = false
+}
+
+// Registers the window class for the first tray in the process, later trays reuse it
+func (t *WinTray) acquireClass(classNamePtr *uint16) error {
+	windowClassLock.Lock()
+	defer windowClassLock.Unlock()
+
+	if windowClassRefs == 0 {
+		wcex := &wndClassEx{
+			Style:      win32.CS_HREDRAW | win32.CS_VREDRAW,
+			WndProc:    wndProcCallback,
+			Instance:   t.instance,
+			Icon:       t.icon,
+			Cursor:     t.cursor,
+			Background: windows.Handle(6), // (COLOR_WINDOW + 1)
+			ClassName:  classNamePtr,
+			IconSm:     t.icon,
+		}
+		if err := wcex.register(); err != nil {
+			return err
+		}
+		windowClass = wcex
+	}
+
+	windowClassRefs++
+	return nil
+}
+
+// Unregisters the window class once the last tray using it has been torn down
+func releaseClass() {
+	windowClassLock.Lock()
+	defer windowClassLock.Unlock()
+
+	windowClassRefs--
+	if windowClassRefs == 0 {
+		windowClass.unregister()
+		windowClass = nil
+	}
+}
+
+// Destroys the window. No loop returns to tear down an attached tray, so it is done once the window is gone,
+// as the window class can not be unregistered while one of its windows exists
+func (t *WinTray) destroy() {
 	win32.DestroyWindow.Call(uintptr(t.window))
-	t.wcex.unregister()
+	if t.Attached {
+		t.OnExit()
+	}
 }
 
 func (t *WinTray) Quit() {
 	win32.PostMessage.Call(uintptr(t.window), win32.WM_CLOSE, 0, 0)
 }
 
+// Wakes the message loop so the OnDispatch callback is run on the thread owning the window.
+// It is safe to call from any thread
+func (t *WinTray) Dispatch() error {
+	res, _, err := win32.PostMessage.Call(uintptr(t.window), uintptr(t.wmDispatch), 0, 0)
+	if res == 0 {
+		return err
+	}
+	return nil
+}
+
 func (t *WinTray) CreateMenu() (uintptr, error) {
 	menuHandle, _, err := win32.CreatePopupMenu.Call()
 	if menuHandle == 0 {
@@ -164,9 +349,17 @@ func (t *WinTray) CreateMenu() (uintptr, error) {
 		return 0, err
 	}
 
+	// Menus attached to a parent are destroyed along with it, so only the rest is tracked
+	t.menus = append(t.menus, menu)
+
 	return uintptr(menu), nil
 }
 
+// Enables waiting for a double click before reporting a left click, which delays left clicks by the double click time
+func (t *WinTray) SetDoubleClickDetection(enabled bool) {
+	t.detectDblClick = enabled
+}
+
 func (t *WinTray) ShowTrayMenu(menu interfaces.Menu) error {
 	p := win32.Point{}
 	res, _, err := win32.GetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
@@ -191,39 +384,159 @@ func (t *WinTray) ShowTrayMenu(menu interfaces.Menu) error {
 	return nil
 }
 
-// Loads an image from file and shows it in tray.
-// LoadImage: https://msdn.microsoft.com/en-us/library/windows/desktop/ms648045(v=vs.85).aspx
-// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
-func (t *WinTray) SetIcon(src string) error {
-	// Save and reuse handles of loaded images
-	h, ok := t.loadedImages[src]
-	if !ok {
-		srcPtr, err := windows.UTF16PtrFromString(src)
-		if err != nil {
-			return err
+// Returns the size in pixels of the small icons shown in the notification area, at the DPI of the monitor the window is on.
+// Before Windows 10 1607 the size of the system DPI is returned, which is 16 unless the application declares itself DPI aware in its manifest
+func (t *WinTray) SmallIconSize() int {
+	if t.window != 0 && win32.GetDpiForWindow.Available() && win32.GetSystemMetricsForDpi.Available() {
+		if dpi, _, _ := win32.GetDpiForWindow.Call(uintptr(t.window)); dpi != 0 {
+			if size, _, _ := win32.GetSystemMetricsForDpi.Call(win32.SM_CXSMICON, dpi); size != 0 {
+				return int(size)
+			}
 		}
-		res, _, err := win32.LoadImage.Call(
-			0,
-			uintptr(unsafe.Pointer(srcPtr)),
-			win32.IMAGE_ICON,
-			0,
-			0,
-			win32.LR_LOADFROMFILE|win32.LR_DEFAULTSIZE,
-		)
-		if res == 0 {
-			return err
+	}
+
+	size, _, _ := win32.GetSystemMetrics.Call(win32.SM_CXSMICON)
+	if size == 0 {
+		return 16
+	}
+	return int(size)
+}
+
+// An icon created by LoadIcon, kept in the order it was last used
+type loadedIcon struct {
+	key    string
+	handle windows.Handle
+}
+
+// Creates an icon from a single image of an .ico file, which holds either PNG or DIB data, to be shown at size pixels.
+// The icon is created once for each key and kept until it is one of the least recently used beyond the cache size, see SetIconCacheSize,
+// or until DeInit
+// CreateIconFromResourceEx: https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-createiconfromresourceex
+func (t *WinTray) LoadIcon(key string, data []byte, size int) (windows.Handle, error) {
+	if e, ok := t.loadedImages[key]; ok {
+		t.iconLRU.MoveToFront(e)
+		return e.Value.(*loadedIcon).handle, nil
+	}
+
+	res, _, err := win32.CreateIconFromResourceEx.Call(
+		uintptr(unsafe.Pointer(&data[0])),
+		uintptr(len(data)),
+		1, // An icon rather than a cursor
+		win32.ICON_RESOURCE_VERSION,
+		uintptr(size),
+		uintptr(size),
+		0,
+	)
+	if res == 0 {
+		return 0, err
+	}
+
+	h := windows.Handle(res)
+	t.loadedImages[key] = t.iconLRU.PushFront(&loadedIcon{key: key, handle: h})
+	t.trimIcons()
+	return h, nil
+}
+
+// Sets how many icons LoadIcon keeps created, zero restores DefaultIconCacheSize.
+// The icon shown is kept regardless, so an animation should keep at least all of its frames
+func (t *WinTray) SetIconCacheSize(size int) {
+	t.iconCacheSize = size
+	t.trimIcons()
+}
+
+// Destroys the least recently used icons beyond the cache size, apart from the icon shown
+func (t *WinTray) trimIcons() {
+	if t.iconLRU == nil {
+		return
+	}
+
+	size := t.iconCacheSize
+	if size <= 0 {
+		size = DefaultIconCacheSize
+	}
+
+	for e := t.iconLRU.Back(); e != nil && t.iconLRU.Len() > size; {
+		prev := e.Prev()
+
+		icon := e.Value.(*loadedIcon)
+		if t.nid == nil || icon.handle != t.nid.Icon {
+			t.iconLRU.Remove(e)
+			delete(t.loadedImages, icon.key)
+			win32.DestroyIcon.Call(uintptr(icon.handle))
 		}
-		h = windows.Handle(res)
-		t.loadedImages[src] = h
+
+		e = prev
+	}
+}
+
+// Reports whether the icon for key has been created, so its data is not needed again
+func (t *WinTray) IconLoaded(key string) bool {
+	_, ok := t.loadedImages[key]
+	return ok
+}
+
+// Sets the icon shown in the tray, see LoadIcon
+// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
+func (t *WinTray) SetIcon(key string, data []byte, size int) error {
+	h, err := t.LoadIcon(key, data, size)
+	if err != nil {
+		return err
 	}
 
 	t.nid.Icon = h
 	t.nid.Flags |= win32.NIF_ICON
 	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
 
+	if err := t.nid.modify(); err != nil {
+		return err
+	}
+
+	// The icon shown before may have been kept only because it was shown
+	t.trimIcons()
+	return nil
+}
+
+// Removes the icon shown in the tray, leaving its place in the notification area empty
+func (t *WinTray) ClearIcon() error {
+	t.nid.Icon = 0
+	t.nid.Flags |= win32.NIF_ICON
+	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
+
+	if err := t.nid.modify(); err != nil {
+		return err
+	}
+
+	// The icon shown before may have been kept only because it was shown
+	t.trimIcons()
+	return nil
+}
+
+// Shows or hides the icon, a hidden icon keeps its place in the notification area
+func (t *WinTray) SetVisible(visible bool) error {
+	t.nid.Flags |= win32.NIF_STATE
+	t.nid.StateMask = win32.NIS_HIDDEN
+	t.nid.State = 0
+	if !visible {
+		t.nid.State = win32.NIS_HIDDEN
+	}
+	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
+
 	return t.nid.modify()
 }
 
+// Calls OnAnimationFrame every interval milliseconds until StopAnimation is called
+func (t *WinTray) StartAnimation(interval uint32) error {
+	res, _, err := win32.SetTimer.Call(uintptr(t.window), animationTimerID, uintptr(interval), 0)
+	if res == 0 {
+		return err
+	}
+	return nil
+}
+
+func (t *WinTray) StopAnimation() {
+	win32.KillTimer.Call(uintptr(t.window), animationTimerID)
+}
+
 // Sets tooltip on icon.
 // Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
 func (t *WinTray) SetTooltip(src string) error {
@@ -257,6 +570,15 @@ func (t *WinTray) SetMenuItem(menuItem interfaces.MenuItem, parentMenu interface
 	if menuItem.IsChecked() {
 		mi.State |= win32.MFS_CHECKED
 	}
+	if item, ok := menuItem.(interfaces.MenuItemDefault); ok && item.IsDefault() {
+		mi.State |= win32.MFS_DEFAULT
+	}
+	bitmap, err := t.menuBitmap(menuItem)
+	if err != nil {
+		return err
+	}
+	mi.Mask |= win32.MIIM_BITMAP
+	mi.Item = bitmap
 	mi.Size = uint32(unsafe.Sizeof(mi))
 
 	// We set the menu item info based on the menuID
@@ -285,6 +607,36 @@ func (t *WinTray) SetMenuItem(menuItem interfaces.MenuItem, parentMenu interface
 	return nil
 }
 
+// Marks the menu item as the default item of its menu, rendering it in bold.
+// https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-setmenudefaultitem
+func (t *WinTray) SetDefaultMenuItem(menuItem interfaces.MenuItem, parentMenu interfaces.Menu) error {
+	res, _, err := win32.SetMenuDefaultItem.Call(
+		uintptr(parentMenu.GetHandle()),
+		uintptr(menuItem.GetID()),
+		0,
+	)
+	if res == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// Removes the default item from the menu the item belongs to.
+func (t *WinTray) ClearDefaultMenuItem(menuItem interfaces.MenuItem, parentMenu interfaces.Menu) error {
+	// A position of -1 indicates the menu should have no default item
+	res, _, err := win32.SetMenuDefaultItem.Call(
+		uintptr(parentMenu.GetHandle()),
+		uintptr(0xFFFFFFFF),
+		0,
+	)
+	if res == 0 {
+		return err
+	}
+
+	return nil
+}
+
 func (t *WinTray) AddSeparator(menuItem interfaces.MenuItem, parentMenu interfaces.Menu) error {
 	mi := menuItemInfo{
 		Mask: win32.MIIM_FTYPE | win32.MIIM_ID | win32.MIIM_STATE,
@@ -353,12 +705,38 @@ func (t *WinTray) AddSubMenuItem(menuItem interfaces.MenuItem, parentMenu interf
 			return 0, err
 		}
 	}
+	t.attachedMenu(windows.Handle(subMenuHandle))
 
 	return subMenuHandle, nil
 }
 
-// WindowProc callback function that processes messages sent to a window.
+// Stops tracking a menu that is now owned by its parent
+func (t *WinTray) attachedMenu(menu windows.Handle) {
+	for i, m := range t.menus {
+		if m == menu {
+			t.menus = append(t.menus[:i], t.menus[i+1:]...)
+			return
+		}
+	}
+}
+
+// WindowProc callback function shared by the windows of all trays, it forwards messages to the tray owning the window.
+// Messages sent while the window is being created arrive before it is known and get the default handling
 // https://msdn.microsoft.com/en-us/library/windows/desktop/ms633573(v=vs.85).aspx
+func wndProc(hWnd windows.Handle, message uint32, wParam, lParam uintptr) uintptr {
+	traysLock.RLock()
+	t := trays[hWnd]
+	traysLock.RUnlock()
+
+	if t == nil {
+		lResult, _, _ := win32.DefWindowProc.Call(uintptr(hWnd), uintptr(message), wParam, lParam)
+		return lResult
+	}
+
+	return t.wndProc(hWnd, message, wParam, lParam)
+}
+
+// Processes the messages sent to the window of this tray
 func (t *WinTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam uintptr) (lResult uintptr) {
 	switch message {
 	case win32.WM_COMMAND:
@@ -366,22 +744,103 @@ func (t *WinTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam ui
 		if menuId != -1 {
 			t.OnMenuItemSelected(menuId)
 		}
+	case win32.WM_CLOSE:
+		t.destroy()
 	case win32.WM_DESTROY:
-		// same as WM_ENDSESSION, but throws 0 exit code after all
-		defer win32.PostQuitMessage.Call(uintptr(int32(0)))
-		fallthrough
-	case win32.WM_ENDSESSION:
+		t.destroyed = true
 		if t.nid != nil {
 			t.nid.delete()
 		}
-		t.OnExit()
+		// The loop tears the tray down once it has received WM_QUIT
+		if !t.Attached {
+			t.OnExit()
+			win32.PostQuitMessage.Call(uintptr(int32(0)))
+		}
+	case win32.WM_ENDSESSION:
+		// wParam is false when the session end was cancelled
+		if wParam != 0 {
+			t.OnSessionEnd()
+			// The process can be terminated as soon as we return, so tear down the window while we still can
+			t.destroy()
+		}
+	case win32.WM_POWERBROADCAST:
+		switch wParam {
+		case win32.PBT_APMSUSPEND:
+			t.suspended = true
+			t.OnSessionChange(SessionSuspend)
+		case win32.PBT_APMRESUMEAUTOMATIC, win32.PBT_APMRESUMESUSPEND:
+			// Both are sent when the user resumes the machine, only report the first
+			if t.suspended {
+				t.suspended = false
+				t.OnSessionChange(SessionResume)
+			}
+		}
+		lResult = 1
+	case win32.WM_WTSSESSION_CHANGE:
+		switch wParam {
+		case win32.WTS_SESSION_LOCK:
+			t.OnSessionChange(SessionLock)
+		case win32.WTS_SESSION_UNLOCK:
+			t.OnSessionChange(SessionUnlock)
+		}
 	case t.wmSystrayMessage:
+		p := win32.Point{}
+		win32.GetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
+
 		switch lParam {
-		case win32.WM_RBUTTONUP, win32.WM_LBUTTONUP:
-			t.OnTrayMenuOpened()
+		case win32.WM_LBUTTONUP:
+			if t.ignoreLButtonUp {
+				// The button up following a double click has already been handled
+				t.ignoreLButtonUp = false
+				break
+			}
+
+			if !t.detectDblClick {
+				t.OnTrayClick(ClickPrimary, p.X, p.Y)
+				break
+			}
+
+			// Wait for the double click time to pass before reporting the click, a modal menu would otherwise swallow the second click
+			t.pendingClick = p
+			doubleClickTime, _, _ := win32.GetDoubleClickTime.Call()
+			win32.SetTimer.Call(uintptr(t.window), clickTimerID, doubleClickTime, 0)
+		case win32.WM_LBUTTONDBLCLK:
+			if !t.detectDblClick {
+				break
+			}
+
+			win32.KillTimer.Call(uintptr(t.window), clickTimerID)
+			t.ignoreLButtonUp = true
+			t.OnTrayClick(ClickDouble, p.X, p.Y)
+		case win32.WM_MBUTTONUP:
+			t.OnTrayClick(ClickSecondary, p.X, p.Y)
+		case win32.WM_RBUTTONUP:
+			t.OnTrayClick(ClickContext, p.X, p.Y)
 		}
+	case win32.WM_TIMER:
+		switch wParam {
+		case clickTimerID:
+			win32.KillTimer.Call(uintptr(t.window), clickTimerID)
+			t.OnTrayClick(ClickPrimary, t.pendingClick.X, t.pendingClick.Y)
+		case animationTimerID:
+			t.OnAnimationFrame()
+		}
+	case win32.WM_SETTINGCHANGE:
+		// Sent for theme, high contrast and many other changes, the tray works out whether anything it uses changed
+		t.OnSettingChange()
+	case win32.WM_DISPLAYCHANGE, win32.WM_DPICHANGED:
+		// The small icon size follows the DPI, so the icon may have to be created again at the new size
+		t.OnDisplayChange()
+	case win32.WM_INITMENUPOPUP:
+		t.OnMenuOpened(wParam)
+	case win32.WM_UNINITMENUPOPUP:
+		t.OnMenuClosed(wParam)
+	case t.wmDispatch:
+		t.OnDispatch()
 	case t.wmTaskbarCreated: // on explorer.exe restarts
-		t.nid.add()
+		if err := t.nid.add(); err != nil {
+			t.OnHostLost(err)
+		}
 	default:
 		// Calls the default window procedure to provide default processing for any window messages that an application does not process.
 		// https://msdn.microsoft.com/en-us/library/windows/desktop/ms633572(v=vs.85).aspx